@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/spf13/pflag"
+)
+
+// applyFlags control how an update is made and reported. They are shared by
+// every command that updates a release right away.
+type applyFlags struct {
+	dryRun      bool
+	wait        bool
+	timeout     int64
+	output      string
+	noNotes     bool
+	noResources bool
+	tests       testFlags
+	watch       watchFlags
+}
+
+func (f *applyFlags) register(fs *pflag.FlagSet) {
+	fs.BoolVar(&f.dryRun, "dry-run", false, "simulate the update")
+	fs.BoolVar(&f.wait, "wait", false, "wait until all resources of the release are ready, for at most --timeout seconds")
+	fs.Int64Var(&f.timeout, "timeout", 300, "time in seconds to wait for any individual Kubernetes operation")
+	fs.StringVarP(&f.output, "output", "o", outputText, "format of the result: text, json or yaml")
+	fs.BoolVar(&f.noNotes, "no-notes", false, "do not print the notes of the chart")
+	fs.BoolVar(&f.noResources, "no-resources", false, "do not print the status of the resources of the release")
+	f.tests.register(fs)
+	f.watch.register(fs)
+}
+
+// check validates the flags before the update is made.
+func (f *applyFlags) check(backend releaseBackend) error {
+	if err := checkOutputFormat(f.output); err != nil {
+		return err
+	}
+	if err := f.tests.check(backend); err != nil {
+		return err
+	}
+	return f.watch.check(backend)
+}
+
+// apply runs update, follows the rollout and tests the new revision if asked
+// to, and prints the result. The update is recorded once its outcome is
+// known, so a failed test or a rollback counts as a failure.
+func (f *applyFlags) apply(backend releaseBackend, update *updateConfigCommand) error {
+	update.opts.DryRun = f.dryRun
+	update.opts.Wait = f.wait
+	update.opts.Timeout = f.timeout
+
+	start := time.Now()
+	res, err := update.update()
+	if err == nil {
+		err = f.verify(backend, res)
+	}
+	update.record(res, err, time.Since(start))
+	if err != nil {
+		return err
+	}
+
+	out := newUpdateResult(res)
+	addReleaseStatus(backend, res, out, !f.noNotes, !f.noResources)
+
+	return writeUpdateResult(os.Stdout, f.output, out)
+}
+
+// verify follows the rollout of an update and tests the new revision, as
+// selected by the flags.
+func (f *applyFlags) verify(backend releaseBackend, res *updater.Result) error {
+	if f.dryRun {
+		return nil
+	}
+
+	// Keep stdout to the result when it is meant for machines.
+	progress := os.Stdout
+	if f.output != outputText {
+		progress = os.Stderr
+	}
+
+	if f.watch.enabled {
+		if err := f.watch.run(backend, res, f.timeout, progress); err != nil {
+			return err
+		}
+	}
+	if f.tests.enabled {
+		return f.tests.run(backend, res, f.wait, progress)
+	}
+	return nil
+}
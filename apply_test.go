@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+)
+
+func TestApplyRecordsTestOutcome(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	j := &journal{file: filepath.Join(dir, "journal.jsonl")}
+	backend := &fakeTestBackend{fakeBackend: newFakeBackend(fakeRelease("web", "prod", 1, "", "a: 1\n")), failed: 1}
+
+	f := &applyFlags{output: outputJSON, noNotes: true, noResources: true}
+	f.tests.enabled = true
+	f.tests.atomic = true
+
+	// The result is not printed when the update fails, and progress goes
+	// to stderr with the JSON output.
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stderr := os.Stderr
+	os.Stderr = devNull
+	defer func() { os.Stderr = stderr }()
+
+	r, err := updater.NewRedactor(updater.RedactConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := &updateConfigCommand{
+		backend: backend,
+		opts:    updater.Options{Release: "web", Set: []string{"a=2"}, Redactor: r},
+		journal: j,
+	}
+	err = f.apply(backend, update)
+	if err == nil || !strings.Contains(err.Error(), "rolled back to revision 1") {
+		t.Fatalf("got error %v, want a rollback", err)
+	}
+	if len(backend.rolledBack) != 1 || backend.rolledBack[0] != 1 {
+		t.Errorf("got rollbacks to %v, want one to revision 1", backend.rolledBack)
+	}
+
+	entries, err := j.query(journalFilter{release: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d journal entries, want 1", len(entries))
+	}
+	if e := entries[0]; e.Outcome != outcomeFailure || !strings.Contains(e.Error, "1 test(s) failed") || e.NewRevision != 2 {
+		t.Errorf("unexpected journal entry %+v", e)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gobwas/glob"
+)
+
+// approvalConfig controls how many people have to sign a plan before it can
+// be applied.
+type approvalConfig struct {
+	// TrustedKeysDir holds one PEM encoded ed25519 public key per signer.
+	// The file name without extension identifies the signer.
+	TrustedKeysDir string         `json:"trustedKeysDir"`
+	Rules          []approvalRule `json:"rules"`
+}
+
+// approvalRule requires MinSigners distinct signers for releases matching both
+// Namespace and Release globs. Empty patterns match everything.
+type approvalRule struct {
+	Namespace  string `json:"namespace"`
+	Release    string `json:"release"`
+	MinSigners int    `json:"minSigners"`
+}
+
+type planSignature struct {
+	Signer    string    `json:"signer"`
+	SignedAt  time.Time `json:"signedAt"`
+	Signature []byte    `json:"signature"`
+}
+
+func (c approvalConfig) trustedKeysDir() string {
+	if c.TrustedKeysDir != "" {
+		return c.TrustedKeysDir
+	}
+	return filepath.Join(helmHome(), "update-config", "trusted-keys")
+}
+
+// minSigners returns the highest requirement of all rules matching the release.
+func (c approvalConfig) minSigners(namespace, release string) (int, error) {
+	min := 0
+	for _, r := range c.Rules {
+		ok, err := globMatch(r.Namespace, namespace)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		if ok, err = globMatch(r.Release, release); err != nil {
+			return 0, err
+		}
+		if ok && r.MinSigners > min {
+			min = r.MinSigners
+		}
+	}
+
+	return min, nil
+}
+
+func globMatch(pattern, s string) (bool, error) {
+	if pattern == "" {
+		return true, nil
+	}
+
+	g, err := glob.Compile(pattern)
+	if err != nil {
+		return false, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+	}
+
+	return g.Match(s), nil
+}
+
+// signedContent is the part of the plan covered by signatures: everything
+// except the signatures themselves.
+func (p *plan) signedContent() ([]byte, error) {
+	unsigned := *p
+	unsigned.AuthorSignature = nil
+	unsigned.Signatures = nil
+	return json.Marshal(unsigned)
+}
+
+// signAsAuthor signs the plan with the key of its author.
+func (p *plan) signAsAuthor(key ed25519.PrivateKey) error {
+	content, err := p.signedContent()
+	if err != nil {
+		return err
+	}
+
+	p.AuthorSignature = &planSignature{
+		Signer:    p.Author,
+		SignedAt:  time.Now().UTC(),
+		Signature: ed25519.Sign(key, content),
+	}
+
+	return nil
+}
+
+func (p *plan) sign(signer string, key ed25519.PrivateKey) error {
+	content, err := p.signedContent()
+	if err != nil {
+		return err
+	}
+
+	p.Signatures = append(p.Signatures, planSignature{
+		Signer:    signer,
+		SignedAt:  time.Now().UTC(),
+		Signature: ed25519.Sign(key, content),
+	})
+
+	return nil
+}
+
+// verifyApprovals checks that the plan is signed by its author and carries
+// enough valid signatures from other trusted keys for the given release. At
+// least one approval is always required. Signers are counted by key, so
+// neither the key of the author nor a key trusted under several names adds
+// approvals, and every signature present has to be valid.
+func verifyApprovals(cfg approvalConfig, p *plan, namespace string) error {
+	min, err := cfg.minSigners(namespace, p.Release)
+	if err != nil {
+		return err
+	}
+	if min < 1 {
+		min = 1
+	}
+
+	if p.AuthorSignature == nil {
+		return fmt.Errorf("plan for %s/%s is not signed by its author", namespace, p.Release)
+	}
+	if len(p.Signatures) == 0 {
+		return fmt.Errorf("plan for %s/%s is not signed, %d approval(s) required", namespace, p.Release, min)
+	}
+
+	keys, err := loadTrustedKeys(cfg.trustedKeysDir())
+	if err != nil {
+		return err
+	}
+
+	content, err := p.signedContent()
+	if err != nil {
+		return err
+	}
+
+	// The author is covered by the signatures, so it cannot be changed to
+	// somebody else after the plan was signed.
+	authorKey, ok := keys[p.Author]
+	if !ok {
+		return fmt.Errorf("author %q of the plan is not a trusted signer", p.Author)
+	}
+	if !ed25519.Verify(authorKey, content, p.AuthorSignature.Signature) {
+		return fmt.Errorf("invalid signature by the author %q: the plan has been modified after signing", p.Author)
+	}
+
+	approvals := make(map[string]bool)
+	for _, s := range p.Signatures {
+		key, ok := keys[s.Signer]
+		if !ok {
+			return fmt.Errorf("plan is signed by %q, which is not a trusted signer", s.Signer)
+		}
+		if !ed25519.Verify(key, content, s.Signature) {
+			return fmt.Errorf("invalid signature by %q: the plan has been modified after signing", s.Signer)
+		}
+		if !bytes.Equal(key, authorKey) {
+			approvals[string(key)] = true
+		}
+	}
+
+	if len(approvals) < min {
+		return fmt.Errorf("plan for %s/%s has %d approval(s) besides its author %q, %d required", namespace, p.Release, len(approvals), p.Author, min)
+	}
+
+	return nil
+}
+
+func loadTrustedKeys(dir string) (map[string]ed25519.PublicKey, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading trusted keys: %s", err)
+	}
+
+	keys := make(map[string]ed25519.PublicKey)
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+
+		filename := filepath.Join(dir, f.Name())
+		key, err := readPublicKey(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		keys[name] = key
+	}
+
+	return keys, nil
+}
+
+func readPublicKey(filename string) (ed25519.PublicKey, error) {
+	der, err := readPEM(filename, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 public key", filename)
+	}
+
+	return pub, nil
+}
+
+func readPrivateKey(filename string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(filename, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 private key", filename)
+	}
+
+	return priv, nil
+}
+
+func readPEM(filename, blockType string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s: no PEM block of type %q found", filename, blockType)
+	}
+
+	return block.Bytes, nil
+}
+
+// signerNames lists the signers of a plan for display.
+func signerNames(p *plan) []string {
+	var names []string
+	for _, s := range p.Signatures {
+		names = append(names, s.Signer)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTrustedKey creates a key pair and trusts its public key as name.
+func writeTrustedKey(t *testing.T, dir, name string) ed25519.PrivateKey {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustKey(t, dir, name, pub)
+	return priv
+}
+
+func trustKey(t *testing.T, dir, name string, pub ed25519.PublicKey) {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".pub"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyApprovals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trusted-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	alice := writeTrustedKey(t, dir, "alice")
+	bob := writeTrustedKey(t, dir, "bob")
+	carol := writeTrustedKey(t, dir, "carol")
+	trustKey(t, dir, "bobby", bob.Public().(ed25519.PublicKey))
+	trustKey(t, dir, "alice2", alice.Public().(ed25519.PublicKey))
+	_, mallory, _ := ed25519.GenerateKey(rand.Reader)
+
+	cfg := approvalConfig{
+		TrustedKeysDir: dir,
+		Rules:          []approvalRule{{Namespace: "prod", MinSigners: 2}},
+	}
+
+	newPlan := func(author string, key ed25519.PrivateKey) *plan {
+		p := &plan{Release: "web", Namespace: "prod", BaseRevision: 3, Author: author, NewConfig: "a: 1\n"}
+		if key != nil {
+			if err := p.signAsAuthor(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return p
+	}
+	sign := func(p *plan, signer string, key ed25519.PrivateKey) *plan {
+		if err := p.sign(signer, key); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name      string
+		plan      *plan
+		namespace string
+		err       string
+	}{
+		{
+			name:      "approved",
+			plan:      sign(sign(newPlan("alice", alice), "bob", bob), "carol", carol),
+			namespace: "prod",
+		},
+		{
+			name:      "one approval without rules",
+			plan:      sign(newPlan("alice", alice), "bob", bob),
+			namespace: "dev",
+		},
+		{
+			name:      "unsigned without rules",
+			plan:      newPlan("alice", alice),
+			namespace: "dev",
+			err:       "not signed, 1 approval(s) required",
+		},
+		{
+			name:      "without author signature",
+			plan:      sign(newPlan("alice", nil), "bob", bob),
+			namespace: "dev",
+			err:       "not signed by its author",
+		},
+		{
+			name:      "too few approvals",
+			plan:      sign(newPlan("alice", alice), "bob", bob),
+			namespace: "prod",
+			err:       "has 1 approval(s) besides its author",
+		},
+		{
+			name:      "author approving under another name",
+			plan:      sign(sign(newPlan("alice", alice), "alice2", alice), "bob", bob),
+			namespace: "prod",
+			err:       "has 1 approval(s) besides its author",
+		},
+		{
+			name:      "same key under two names",
+			plan:      sign(sign(newPlan("alice", alice), "bob", bob), "bobby", bob),
+			namespace: "prod",
+			err:       "has 1 approval(s) besides its author",
+		},
+		{
+			name: "author passed off as somebody else",
+			// Alice claims carol wrote the plan to approve it herself.
+			plan:      sign(sign(newPlan("carol", alice), "alice", alice), "bob", bob),
+			namespace: "prod",
+			err:       `invalid signature by the author "carol"`,
+		},
+		{
+			name:      "untrusted author",
+			plan:      sign(newPlan("mallory", mallory), "bob", bob),
+			namespace: "dev",
+			err:       `author "mallory" of the plan is not a trusted signer`,
+		},
+		{
+			name:      "untrusted signer",
+			plan:      sign(sign(newPlan("alice", alice), "bob", bob), "mallory", mallory),
+			namespace: "dev",
+			err:       `"mallory", which is not a trusted signer`,
+		},
+		{
+			name:      "forged signature",
+			plan:      sign(sign(newPlan("alice", alice), "bob", bob), "carol", mallory),
+			namespace: "dev",
+			err:       `invalid signature by "carol"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyApprovals(cfg, tt.plan, tt.namespace)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyApprovalsModifiedPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trusted-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	alice := writeTrustedKey(t, dir, "alice")
+	bob := writeTrustedKey(t, dir, "bob")
+
+	p := &plan{Release: "web", Namespace: "prod", Author: "alice", NewConfig: "a: 1\n"}
+	if err := p.signAsAuthor(alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.sign("bob", bob); err != nil {
+		t.Fatal(err)
+	}
+	p.NewConfig = "a: 2\n"
+
+	err = verifyApprovals(approvalConfig{TrustedKeysDir: dir}, p, "prod")
+	if err == nil || !strings.Contains(err.Error(), "modified after signing") {
+		t.Fatalf("got error %v, want a modified plan error", err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"k8s.io/helm/pkg/helm"
+)
+
+// releaseBackend is an updater.Backend holding connections that have to be
+// closed.
+type releaseBackend interface {
+	updater.Backend
+	// Close releases connections held by the backend.
+	Close() error
+}
+
+// Global flags selecting the backend.
+var (
+	backendName     string
+	kubeConfigFile  string
+	kubeContextName string
+	namespace       string
+	tillerNamespace string
+)
+
+const (
+	backendTiller    = "tiller"
+	backendSecret    = "secret"
+	backendConfigMap = "configmap"
+)
+
+func newBackend() (releaseBackend, error) {
+	switch backendName {
+	case backendTiller, "":
+		return newTillerBackend()
+	case backendSecret, backendConfigMap:
+		kube, err := newKubeClient(kubeConfigFile, kubeContextName)
+		if err != nil {
+			return nil, err
+		}
+		return &storageBackend{kube: kube, driver: backendName, namespace: namespace}, nil
+	}
+
+	return nil, fmt.Errorf("unknown backend %q: use %s, %s or %s", backendName, backendTiller, backendSecret, backendConfigMap)
+}
+
+// tillerBackend talks to Tiller, as Helm 2 does.
+type tillerBackend struct {
+	*updater.TillerBackend
+	tunnel *tunnel
+}
+
+// newTillerBackend connects to the Tiller given by helm. When the plugin is
+// run on its own, it opens a port-forward to the Tiller pod instead.
+func newTillerBackend() (*tillerBackend, error) {
+	return connectTiller(tillerHost(), kubeContextName)
+}
+
+// connectTiller connects to Tiller at host or, if host is empty, through a
+// port-forward to the Tiller pod of the kubeconfig context.
+func connectTiller(host, kubeContext string) (*tillerBackend, error) {
+	if host != "" {
+		return &tillerBackend{TillerBackend: &updater.TillerBackend{Client: helm.NewClient(helm.Host(host))}}, nil
+	}
+
+	kube, err := newKubeClient(kubeConfigFile, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := newTillerTunnel(kube, tillerNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tillerBackend{
+		TillerBackend: &updater.TillerBackend{Client: helm.NewClient(helm.Host(t.Addr()))},
+		tunnel:        t,
+	}, nil
+}
+
+func (b *tillerBackend) Close() error {
+	if b.tunnel != nil {
+		return b.tunnel.Close()
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// fakeBackend keeps the revisions of releases in memory.
+type fakeBackend struct {
+	mu       sync.Mutex
+	releases map[string][]*release.Release
+	// updateErr is returned by Update if set.
+	updateErr error
+	// onUpdate is called before a revision is written.
+	onUpdate func(current *release.Release)
+}
+
+func newFakeBackend(rels ...*release.Release) *fakeBackend {
+	b := &fakeBackend{releases: make(map[string][]*release.Release)}
+	for _, r := range rels {
+		b.releases[r.Name] = append(b.releases[r.Name], r)
+	}
+	return b
+}
+
+// fakeRelease returns a deployed release with a chart whose values are
+// defaults and the user-supplied config.
+func fakeRelease(name, namespace string, version int32, defaults, config string) *release.Release {
+	return &release.Release{
+		Name:      name,
+		Namespace: namespace,
+		Version:   version,
+		Chart: &chart.Chart{
+			Metadata: &chart.Metadata{Name: "app", Version: "1.0.0"},
+			Values:   &chart.Config{Raw: defaults},
+		},
+		Config: &chart.Config{Raw: config},
+		Info:   &release.Info{Status: &release.Status{Code: release.Status_DEPLOYED}},
+	}
+}
+
+func (b *fakeBackend) Close() error {
+	return nil
+}
+
+func (b *fakeBackend) Release(name string) (*release.Release, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	revs := b.releases[name]
+	if len(revs) == 0 {
+		return nil, &updater.TillerError{Op: "GetReleaseContent", Err: fmt.Errorf("release: %q not found", name)}
+	}
+	return revs[len(revs)-1], nil
+}
+
+func (b *fakeBackend) ListReleases() ([]*release.Release, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var rels []*release.Release
+	for _, revs := range b.releases {
+		rels = append(rels, revs[len(revs)-1])
+	}
+	return rels, nil
+}
+
+func (b *fakeBackend) History(name string, max int32) ([]*release.Release, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	revs := b.releases[name]
+	if int(max) < len(revs) {
+		revs = revs[len(revs)-int(max):]
+	}
+	return revs, nil
+}
+
+func (b *fakeBackend) Update(current *release.Release, req updater.Request) (*release.Release, error) {
+	if b.onUpdate != nil && !req.DryRun {
+		b.onUpdate(current)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.updateErr != nil {
+		return nil, b.updateErr
+	}
+
+	raw := string(req.Values)
+	if req.ReuseValues {
+		old, err := chartutil.ReadValues([]byte(current.GetConfig().GetRaw()))
+		if err != nil {
+			return nil, err
+		}
+		vals, err := chartutil.ReadValues(req.Values)
+		if err != nil {
+			return nil, err
+		}
+		merged, _ := updater.ApplyMergePatch(map[string]interface{}(old), map[string]interface{}(vals)).(map[string]interface{})
+		if raw, err = chartutil.Values(merged).YAML(); err != nil {
+			return nil, err
+		}
+	}
+
+	revs := b.releases[current.Name]
+	next := *revs[len(revs)-1]
+	next.Version++
+	next.Config = &chart.Config{Raw: raw}
+	if !req.DryRun {
+		b.releases[current.Name] = append(revs, &next)
+	}
+	return &next, nil
+}
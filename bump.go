@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Masterminds/semver"
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/spf13/cobra"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+func newBumpCmd() *cobra.Command {
+	var (
+		flags      updateFlags
+		apply      applyFlags
+		major      bool
+		minor      bool
+		patch      bool
+		prerelease string
+		force      bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "bump [flags] RELEASE KEY",
+		Short: "increment a semantic version value of a release, such as image.tag",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			release, key := args[0], args[1]
+
+			part, err := bumpPart(major, minor, patch, prerelease)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := readConfig()
+			if err != nil {
+				return err
+			}
+
+			backend, err := newBackend()
+			if err != nil {
+				return err
+			}
+			defer backend.Close()
+
+			if err := apply.check(backend); err != nil {
+				return err
+			}
+
+			update, err := flags.command(cfg, backend, release)
+			if err != nil {
+				return err
+			}
+
+			rel, err := backend.Release(release)
+			if err != nil {
+				return err
+			}
+			vals, err := chartutil.CoalesceValues(rel.Chart, rel.Config)
+			if err != nil {
+				return err
+			}
+			v, ok := updater.LookupValue(vals, key)
+			if !ok {
+				return fmt.Errorf("%s is not set in release %s", key, release)
+			}
+			current := updater.FormatValue(v)
+
+			next, err := nextVersion(current, part, prerelease, force)
+			if err != nil {
+				return err
+			}
+
+			if apply.output == outputText {
+				fmt.Fprintf(os.Stdout, "Bumping %s from %s to %s\n", key, current, next)
+			}
+
+			if update.opts.Values == nil {
+				update.opts.Values = make(map[string]interface{})
+			}
+			updater.SetValue(update.opts.Values, key, next)
+			// Fail instead of skipping a version if someone else bumps it
+			// at the same time.
+			update.opts.Preconditions = append(update.opts.Preconditions, updater.Precondition{Path: key, Value: current})
+
+			return apply.apply(backend, update)
+		},
+	}
+
+	flags.register(cmd.Flags())
+	apply.register(cmd.Flags())
+	cmd.Flags().BoolVar(&major, "major", false, "increment the major version")
+	cmd.Flags().BoolVar(&minor, "minor", false, "increment the minor version")
+	cmd.Flags().BoolVar(&patch, "patch", false, "increment the patch version")
+	cmd.Flags().StringVar(&prerelease, "to-prerelease", "", "move to the next prerelease with this identifier, e.g. rc: 1.2.3 becomes 1.2.4-rc.1 and 1.2.4-rc.1 becomes 1.2.4-rc.2")
+	cmd.Flags().BoolVar(&force, "force", false, "bump values which are not complete semantic versions and allow downgrades")
+
+	return cmd
+}
+
+func bumpPart(major, minor, patch bool, prerelease string) (string, error) {
+	var parts []string
+	if major {
+		parts = append(parts, updater.BumpMajor)
+	}
+	if minor {
+		parts = append(parts, updater.BumpMinor)
+	}
+	if patch {
+		parts = append(parts, updater.BumpPatch)
+	}
+
+	switch {
+	case len(parts) > 1:
+		return "", errors.New("only one of --major, --minor and --patch can be given")
+	case len(parts) == 1:
+		return parts[0], nil
+	case prerelease != "":
+		return updater.BumpPatch, nil
+	}
+
+	return "", errors.New("one of --major, --minor, --patch or --to-prerelease is required")
+}
+
+// nextVersion computes the version after current. Unless forced, current has
+// to be a complete semantic version and the result must not be lower than it.
+func nextVersion(current, part, prerelease string, force bool) (string, error) {
+	if !force && !updater.IsSemver(current) {
+		return "", fmt.Errorf("%q is not a semantic version, use --force to bump it anyway", current)
+	}
+
+	var (
+		next string
+		err  error
+	)
+	if prerelease != "" {
+		next, err = updater.BumpPrerelease(current, part, prerelease)
+	} else {
+		next, err = updater.BumpVersion(current, part)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	cv, err := semver.NewVersion(current)
+	if err != nil {
+		return "", err
+	}
+	nv, err := semver.NewVersion(next)
+	if err != nil {
+		return "", err
+	}
+	if !force && nv.LessThan(cv) {
+		return "", fmt.Errorf("%s is lower than %s, use --force to downgrade", next, current)
+	}
+
+	return next, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+)
+
+func TestNextVersion(t *testing.T) {
+	tests := []struct {
+		current    string
+		part       string
+		prerelease string
+		force      bool
+		want       string
+		err        string
+	}{
+		{current: "v1.2.3", part: updater.BumpPatch, want: "v1.2.4"},
+		{current: "1.2.4-rc.2", part: updater.BumpMajor, prerelease: "rc", want: "2.0.0-rc.1"},
+		{current: "1.2.4-rc.2", part: updater.BumpPatch, prerelease: "beta", err: "lower than"},
+		{current: "1.2.4-rc.2", part: updater.BumpPatch, prerelease: "beta", force: true, want: "1.2.4-beta.1"},
+		{current: "1.2", part: updater.BumpPatch, err: "use --force"},
+		{current: "1.2", part: updater.BumpPatch, force: true, want: "1.2.1"},
+	}
+
+	for _, tt := range tests {
+		got, err := nextVersion(tt.current, tt.part, tt.prerelease, tt.force)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("nextVersion(%q): got error %v, want %q", tt.current, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("nextVersion(%q): %s", tt.current, err)
+		} else if got != tt.want {
+			t.Errorf("nextVersion(%q) = %q, want %q", tt.current, got, tt.want)
+		}
+	}
+}
+
+func TestBumpPart(t *testing.T) {
+	if part, err := bumpPart(false, false, false, "rc"); err != nil || part != updater.BumpPatch {
+		t.Errorf("got %q, %v for --to-prerelease alone, want patch", part, err)
+	}
+	if part, err := bumpPart(true, false, false, "rc"); err != nil || part != updater.BumpMajor {
+		t.Errorf("got %q, %v for --major --to-prerelease, want major", part, err)
+	}
+	if _, err := bumpPart(true, true, false, ""); err == nil {
+		t.Error("--major and --minor were accepted together")
+	}
+	if _, err := bumpPart(false, false, false, ""); err == nil {
+		t.Error("no part was accepted")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/ghodss/yaml"
+	"github.com/spf13/cobra"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/strvals"
+)
+
+func newCloneCmd() *cobra.Command {
+	var (
+		values         []string
+		env            envFlags
+		dryRun         bool
+		wait           bool
+		timeout        int64
+		skipImageCheck bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "clone [flags] SRC_RELEASE NEW_RELEASE",
+		Short: "install a new release with the chart and config of an existing one, e.g. for a preview environment",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			srcName, name := args[0], args[1]
+
+			cfg, err := readConfig()
+			if err != nil {
+				return err
+			}
+
+			backend, err := newBackend()
+			if err != nil {
+				return err
+			}
+			defer backend.Close()
+
+			inst, ok := backend.(releaseInstaller)
+			if !ok {
+				return fmt.Errorf("clone is not supported by the %s backend", backendName)
+			}
+
+			req := updater.Request{DryRun: dryRun, Wait: wait, Timeout: timeout}
+			src, rel, err := cloneCommand(cfg, inst, srcName, name, values, &env, req, !skipImageCheck)
+			if err != nil {
+				return err
+			}
+
+			verb := "installed"
+			if dryRun {
+				verb = "would be installed"
+			}
+			fmt.Printf("Release %s %s in namespace %s as a clone of %s at revision %d\n", rel.GetName(), verb, rel.GetNamespace(), srcName, src.GetVersion())
+			fmt.Printf("Status: %s\n", rel.GetInfo().GetStatus().GetCode())
+			fmt.Printf("Chart: %s-%s\n", rel.GetChart().GetMetadata().GetName(), rel.GetChart().GetMetadata().GetVersion())
+			if notes := rel.GetInfo().GetStatus().GetNotes(); notes != "" {
+				fmt.Printf("\nNotes:\n%s\n", strings.TrimRight(notes, "\n"))
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&values, "set", []string{}, "override values of the source release (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	env.register(cmd.Flags())
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "simulate the install")
+	cmd.Flags().BoolVar(&wait, "wait", false, "wait until all resources of the new release are ready, for at most --timeout seconds")
+	cmd.Flags().Int64Var(&timeout, "timeout", 300, "time in seconds to wait for any individual Kubernetes operation")
+	cmd.Flags().BoolVar(&skipImageCheck, "skip-image-check", false, "do not check that the images of the new manifest exist in their registries")
+
+	return cmd
+}
+
+// releaseInstaller is a backend which can install new releases.
+type releaseInstaller interface {
+	releaseBackend
+	Install(ch *chart.Chart, namespace, name string, req updater.Request) (*release.Release, error)
+}
+
+// cloneCommand installs name with the chart and config of srcName, overridden
+// by env and values, and records the install in the journal. It returns the
+// source release and the new one.
+func cloneCommand(cfg *config, inst releaseInstaller, srcName, name string, values []string, env *envFlags, req updater.Request, checkImages bool) (*release.Release, *release.Release, error) {
+	r, err := cfg.redactor()
+	if err != nil {
+		return nil, nil, err
+	}
+	n, err := cfg.notifier()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	src, err := inst.Release(srcName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vals, err := chartutil.ReadValues([]byte(src.GetConfig().GetRaw()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("config of %s: %s", srcName, err)
+	}
+	envVals, err := env.values()
+	if err != nil {
+		return nil, nil, err
+	}
+	merged, _ := updater.ApplyMergePatch(map[string]interface{}(vals), envVals).(map[string]interface{})
+	for _, s := range values {
+		if err := strvals.ParseInto(s, merged); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	req.Values, err = yaml.Marshal(merged)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ns := namespace
+	if ns == "" {
+		ns = src.Namespace
+	}
+
+	// The install is recorded like an update from an empty config.
+	update := &updateConfigCommand{
+		backend:  inst,
+		opts:     updater.Options{Release: name, Set: values, Redactor: r, DryRun: req.DryRun},
+		env:      *env,
+		journal:  cfg.journal(),
+		notifier: n,
+		action:   "clone",
+		source:   srcName,
+	}
+	res := &updater.Result{
+		Release:   name,
+		Namespace: ns,
+		Config:    req.Values,
+		Changes:   r.Changes(updater.DiffValues(map[string]interface{}{}, merged)),
+		DryRun:    req.DryRun,
+	}
+
+	start := time.Now()
+	rel, err := cloneRelease(inst, src, ns, name, req, checkImages)
+	err = r.Error(err, merged)
+	if rel != nil {
+		res.Updated = rel
+		res.Revision = rel.GetVersion()
+		res.Status = rel.GetInfo().GetStatus().GetCode()
+	}
+	update.record(res, err, time.Since(start))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return src, rel, nil
+}
+
+// cloneRelease installs the chart of src as a new release. Unless checkImages
+// is false, the images of the manifest are checked first, with a dry run that
+// is reused when req is one.
+func cloneRelease(inst releaseInstaller, src *release.Release, namespace, name string, req updater.Request, checkImages bool) (*release.Release, error) {
+	if checkImages {
+		dryReq := req
+		dryReq.DryRun = true
+		dry, err := inst.Install(src.Chart, namespace, name, dryReq)
+		if err != nil {
+			return nil, err
+		}
+		warnings, err := updater.CheckImages(imageRegistry, dry.GetManifest())
+		printWarnings(warnings)
+		if err != nil {
+			return nil, err
+		}
+		if req.DryRun {
+			return dry, nil
+		}
+	}
+
+	return inst.Install(src.Chart, namespace, name, req)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// fakeInstaller records the installs made through it.
+type fakeInstaller struct {
+	*fakeBackend
+	installs []updater.Request
+}
+
+func (b *fakeInstaller) Install(ch *chart.Chart, namespace, name string, req updater.Request) (*release.Release, error) {
+	b.installs = append(b.installs, req)
+	rel := fakeRelease(name, namespace, 1, "", string(req.Values))
+	rel.Chart = ch
+	return rel, nil
+}
+
+func TestCloneCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config{Journal: journalConfig{File: filepath.Join(dir, "journal.jsonl")}}
+	inst := &fakeInstaller{fakeBackend: newFakeBackend(
+		fakeRelease("web", "prod", 4, "", "image:\n  tag: v2\nhost: web.example.com\npassword: hunter22\n"),
+	)}
+	env := &envFlags{dotenvFiles: []string{writeTempFile(t, dir, ".env", "image.tag=v3\n")}}
+
+	src, rel, err := cloneCommand(cfg, inst, "web", "web-pr-1", []string{"host=pr-1.example.com"}, env, updater.Request{Wait: true}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Version != 4 || rel.Name != "web-pr-1" || rel.Namespace != "prod" {
+		t.Errorf("cloned %s at revision %d into %s in %s", src.Name, src.Version, rel.Name, rel.Namespace)
+	}
+	if len(inst.installs) != 1 {
+		t.Fatalf("got %d installs, want 1", len(inst.installs))
+	}
+	if req := inst.installs[0]; string(req.Values) != "host: pr-1.example.com\nimage:\n  tag: v3\npassword: hunter22\n" || !req.Wait {
+		t.Errorf("installed with values %q and Wait %v", req.Values, req.Wait)
+	}
+
+	entries, err := cfg.journal().query(journalFilter{release: "web-pr-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d journal entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.Action != "clone" || e.Source != "web" || e.Namespace != "prod" || e.NewRevision != 1 || e.Outcome != outcomeSuccess {
+		t.Errorf("unexpected journal entry: %+v", e)
+	}
+	if len(e.Diff) != 3 {
+		t.Errorf("got diff %+v, want the three values added", e.Diff)
+	}
+	for _, c := range e.Diff {
+		if c.Key == "password" && !c.Redacted {
+			t.Errorf("password is not redacted in the journal: %+v", c)
+		}
+	}
+
+	// Dry runs are not journaled.
+	if _, _, err := cloneCommand(cfg, inst, "web", "web-pr-2", nil, &envFlags{}, updater.Request{DryRun: true}, false); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := cfg.journal().query(journalFilter{release: "web-pr-2"}); len(entries) != 0 {
+		t.Errorf("got %d journal entries of a dry run", len(entries))
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/ghodss/yaml"
+)
+
+// config is the plugin configuration file. Every section is optional.
+type config struct {
+	Approvals approvalConfig `json:"approvals"`
+	Journal   journalConfig  `json:"journal"`
+	Redact    redactConfig   `json:"redact"`
+	Images    imagesConfig   `json:"images"`
+	Promote   promoteConfig  `json:"promote"`
+	Serve     serveConfig    `json:"serve"`
+	Notify    notifyConfig   `json:"notify"`
+}
+
+// imagesConfig tells where the images of releases are found in their values.
+type imagesConfig struct {
+	Paths []updater.ImagePath `json:"paths"`
+}
+
+// Global flags overriding the config file.
+var (
+	configFile  string
+	journalFile string
+)
+
+// readConfig loads the config file selected with --config and applies the
+// global flags on top of it.
+func readConfig() (*config, error) {
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if journalFile != "" {
+		cfg.Journal.File = journalFile
+		cfg.Journal.Disabled = false
+	}
+	for _, u := range notifyURLs {
+		cfg.Notify.Webhooks = append(cfg.Notify.Webhooks, webhookConfig{URL: u, Format: notifyFormat})
+	}
+
+	return cfg, nil
+}
+
+// redactConfig adds the key used for fingerprints to the redaction settings.
+type redactConfig struct {
+	updater.RedactConfig
+	// KeyFile holds the key of this installation, so that fingerprints can be
+	// compared between runs but not reversed. It is created on first use.
+	KeyFile string `json:"keyFile"`
+}
+
+func (c redactConfig) keyFile() string {
+	if c.KeyFile != "" {
+		return c.KeyFile
+	}
+	return filepath.Join(helmHome(), "update-config", "redact.key")
+}
+
+func (c *config) redactor() (*updater.Redactor, error) {
+	key, err := loadRedactKey(c.Redact.keyFile())
+	if err != nil {
+		return nil, err
+	}
+
+	rc := c.Redact.RedactConfig
+	rc.Key = key
+	return updater.NewRedactor(rc)
+}
+
+// loadRedactKey reads the redaction key from filename, creating the file with
+// a random key if it does not exist yet.
+func loadRedactKey(filename string) ([]byte, error) {
+	key, err := ioutil.ReadFile(filename)
+	if err == nil {
+		if len(key) == 0 {
+			return nil, fmt.Errorf("redaction key %s is empty", filename)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading redaction key: %s", err)
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating redaction key: %s", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return nil, fmt.Errorf("creating redaction key: %s", err)
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		// Created by another run in the meantime.
+		return loadRedactKey(filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating redaction key: %s", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(key); err != nil {
+		return nil, fmt.Errorf("writing redaction key: %s", err)
+	}
+	return key, f.Close()
+}
+
+// journal returns the configured journal, or nil if it is disabled.
+func (c *config) journal() *journal {
+	if c.Journal.Disabled {
+		return nil
+	}
+	return &journal{file: c.Journal.file()}
+}
+
+// defaultConfigFile returns the config file location inside the Helm home
+// directory. Helm sets HELM_HOME when it runs a plugin.
+func defaultConfigFile() string {
+	return filepath.Join(helmHome(), "update-config.yaml")
+}
+
+func helmHome() string {
+	if home := os.Getenv("HELM_HOME"); home != "" {
+		return home
+	}
+	return filepath.Join(os.Getenv("HOME"), ".helm")
+}
+
+// loadConfig reads the config file. A missing file yields an empty config.
+func loadConfig(filename string) (*config, error) {
+	var cfg config
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// watchDir returns a channel receiving a value soon after a file in dir is
+// written, created, renamed or removed, and a function which stops watching.
+// Events are coalesced: the receiver is expected to look at the directory
+// itself.
+func watchDir(dir string) (<-chan struct{}, func(), error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// Partial writes are not of interest, only the closing of a written
+	// file. Renames cover editors and checkouts replacing files.
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil, nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	// A non-blocking file is read through the runtime poller, so closing it
+	// ends a read in progress.
+	f := os.NewFile(uintptr(fd), "inotify")
+	changes := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			if _, err := f.Read(buf); err != nil {
+				return
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changes, func() { f.Close() }, nil
+}
@@ -0,0 +1,86 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWatchDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	changes, unwatch, err := watchDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTempFile(t, dir, "web.yaml", "replicas: 3\n")
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writing a file was not noticed")
+	}
+
+	unwatch()
+	writeTempFile(t, dir, "api.yaml", "replicas: 3\n")
+	select {
+	case <-changes:
+		t.Error("a change was noticed after watching stopped")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestReconcilerRunWatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconcile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := newFakeBackend(fakeRelease("web", "prod", 1, "", "replicas: 2\n"))
+	writeTempFile(t, dir, "web.yaml", "replicas: 3\n")
+	r, reconciles := newTestReconciler(t, dir, backend)
+
+	waitReconciles := func(n int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); reconciles() < n; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d reconciles, want %d", reconciles(), n)
+			}
+		}
+	}
+
+	// Neither the interval nor polling come around during the test, so only
+	// watching the directory can start the second reconcile.
+	stop := make(chan os.Signal, 1)
+	done := make(chan error)
+	go func() { done <- r.run(time.Hour, time.Hour, stop) }()
+
+	waitReconciles(1)
+	// A change during a reconcile is only picked up by the next poll.
+	for running := true; running; time.Sleep(10 * time.Millisecond) {
+		r.mu.Lock()
+		running = r.releases["web"].running
+		r.mu.Unlock()
+	}
+	writeTempFile(t, dir, "web.yaml", "replicas: 40\n")
+	waitReconciles(2)
+
+	stop <- os.Interrupt
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reconciler did not stop")
+	}
+}
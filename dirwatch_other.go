@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// watchDir is only implemented with inotify, other systems fall back to
+// polling.
+func watchDir(dir string) (<-chan struct{}, func(), error) {
+	return nil, nil, errors.New("watching files is not supported on this system")
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/ghodss/yaml"
+	"github.com/spf13/cobra"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// driftReport compares the desired values of a release with its current ones.
+// Its field names are part of the command line interface and must not change.
+type driftReport struct {
+	Release   string `json:"release"`
+	Namespace string `json:"namespace,omitempty"`
+	File      string `json:"file"`
+	// Revision is the revision the values were compared with.
+	Revision int32 `json:"revision,omitempty"`
+	// Extra are set in the release but not desired.
+	Extra []driftValue `json:"extra"`
+	// Missing are desired but not set in the release.
+	Missing []driftValue `json:"missing"`
+	// Different have another value in the release than desired.
+	Different []driftValue `json:"different"`
+	// FixedRevision is the revision written by --fix.
+	FixedRevision int32  `json:"fixedRevision,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// driftValue holds fingerprints instead of values when they are sensitive.
+type driftValue struct {
+	Key      string      `json:"key"`
+	Current  interface{} `json:"current,omitempty"`
+	Desired  interface{} `json:"desired,omitempty"`
+	Redacted bool        `json:"redacted,omitempty"`
+}
+
+func (r *driftReport) drifted() bool {
+	return len(r.Extra)+len(r.Missing)+len(r.Different) > 0
+}
+
+// driftError is returned when releases have drifted from their desired values.
+type driftError struct {
+	releases int
+}
+
+func (e *driftError) Error() string {
+	if e.releases == 1 {
+		return "1 release has drifted from its desired values"
+	}
+	return fmt.Sprintf("%d releases have drifted from their desired values", e.releases)
+}
+
+func newDriftCmd() *cobra.Command {
+	var (
+		file           string
+		dir            string
+		coalesced      bool
+		output         string
+		fix            bool
+		wait           bool
+		timeout        int64
+		skipImageCheck bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "drift [flags] RELEASE -f desired.yaml | drift [flags] --dir DIR",
+		Short: "compare releases with their desired values and report differences",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			desired := make(map[string]string)
+			switch {
+			case dir != "" && (file != "" || len(args) > 0):
+				return errors.New("--dir cannot be combined with a release and -f")
+			case dir != "":
+				var err error
+				if desired, err = desiredFiles(dir); err != nil {
+					return err
+				}
+			case len(args) == 1 && file != "":
+				desired[args[0]] = file
+			default:
+				return errors.New("either a release and -f or --dir is required")
+			}
+			if err := checkOutputFormat(output); err != nil {
+				return err
+			}
+
+			cfg, err := readConfig()
+			if err != nil {
+				return err
+			}
+			r, err := cfg.redactor()
+			if err != nil {
+				return err
+			}
+
+			backend, err := newBackend()
+			if err != nil {
+				return err
+			}
+			defer backend.Close()
+
+			var names []string
+			for name := range desired {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			var (
+				reports = []*driftReport{}
+				drifted int
+				failed  int
+			)
+			for _, name := range names {
+				rep, vals, err := checkDrift(backend, r, name, desired[name], coalesced)
+				if err == nil && rep.drifted() && fix {
+					var update *updateConfigCommand
+					if update, err = fixDriftCommand(cfg, backend, r, rep, vals); err == nil {
+						update.opts.CheckImages = !skipImageCheck
+						update.opts.Wait = wait
+						update.opts.Timeout = timeout
+						var res *updater.Result
+						if res, err = update.run(); err == nil {
+							rep.FixedRevision = res.Revision
+						}
+					}
+				}
+
+				switch {
+				case err != nil:
+					rep.Error = err.Error()
+					failed++
+				case rep.drifted() && rep.FixedRevision == 0:
+					drifted++
+				}
+				reports = append(reports, rep)
+			}
+
+			if err := writeDriftReports(os.Stdout, output, reports); err != nil {
+				return err
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("checking %d of %d releases failed", failed, len(names))
+			}
+			if drifted > 0 {
+				return &driftError{releases: drifted}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "values", "f", "", "file with the desired user-supplied values of the release")
+	cmd.Flags().StringVar(&dir, "dir", "", "directory with the desired values of many releases, one RELEASE.yaml file each")
+	cmd.Flags().BoolVar(&coalesced, "coalesced", false, "compare the values including the defaults of the chart, so that values set to their default are no drift")
+	cmd.Flags().StringVarP(&output, "output", "o", outputText, "format of the result: text, json or yaml")
+	cmd.Flags().BoolVar(&fix, "fix", false, "replace the config of drifted releases with the desired values")
+	cmd.Flags().BoolVar(&wait, "wait", false, "with --fix, wait until all resources of the release are ready, for at most --timeout seconds")
+	cmd.Flags().Int64Var(&timeout, "timeout", 300, "time in seconds to wait for any individual Kubernetes operation")
+	cmd.Flags().BoolVar(&skipImageCheck, "skip-image-check", false, "do not check that the images of the new manifest exist in their registries")
+
+	return cmd
+}
+
+// desiredFiles maps release names to the YAML files in dir.
+func desiredFiles(dir string) (map[string]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string)
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), ext)
+		if prev, ok := files[name]; ok {
+			return nil, fmt.Errorf("%s: both %s and %s hold values of release %s", dir, filepath.Base(prev), e.Name(), name)
+		}
+		files[name] = filepath.Join(dir, e.Name())
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no values files", dir)
+	}
+
+	return files, nil
+}
+
+// checkDrift compares the desired values in file with the current config of
+// a release, or with its coalesced values. It returns the desired values
+// along with the report.
+func checkDrift(backend releaseBackend, r *updater.Redactor, name, file string, coalesced bool) (*driftReport, map[string]interface{}, error) {
+	rep := &driftReport{Release: name, File: file, Extra: []driftValue{}, Missing: []driftValue{}, Different: []driftValue{}}
+
+	desired, err := chartutil.ReadValuesFile(file)
+	if err != nil {
+		return rep, nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	rel, err := backend.Release(name)
+	if err != nil {
+		return rep, nil, err
+	}
+	rep.Namespace = rel.Namespace
+	rep.Revision = rel.Version
+
+	current, err := chartutil.ReadValues([]byte(rel.GetConfig().GetRaw()))
+	if err != nil {
+		return rep, nil, err
+	}
+	want := desired
+	if coalesced {
+		if current, err = chartutil.CoalesceValues(rel.Chart, rel.Config); err != nil {
+			return rep, nil, err
+		}
+		raw, err := desired.YAML()
+		if err != nil {
+			return rep, nil, err
+		}
+		if want, err = chartutil.CoalesceValues(rel.Chart, &chart.Config{Raw: raw}); err != nil {
+			return rep, nil, err
+		}
+	}
+
+	for _, c := range r.Changes(updater.DiffValues(want, current)) {
+		v := driftValue{Key: c.Key, Desired: c.Old, Current: c.New, Redacted: c.Redacted}
+		switch c.Kind {
+		case updater.ChangeAdded:
+			rep.Extra = append(rep.Extra, v)
+		case updater.ChangeRemoved:
+			rep.Missing = append(rep.Missing, v)
+		default:
+			rep.Different = append(rep.Different, v)
+		}
+	}
+
+	return rep, desired, nil
+}
+
+// fixDriftCommand returns the update replacing the config of a release with
+// its desired values. The update fails if the release has changed since the
+// drift was reported.
+func fixDriftCommand(cfg *config, backend releaseBackend, r *updater.Redactor, rep *driftReport, vals map[string]interface{}) (*updateConfigCommand, error) {
+	n, err := cfg.notifier()
+	if err != nil {
+		return nil, err
+	}
+
+	return &updateConfigCommand{
+		backend: backend,
+		opts: updater.Options{
+			Release:     rep.Release,
+			IfRevision:  rep.Revision,
+			Values:      vals,
+			ResetValues: true,
+			Redactor:    r,
+			Registry:    imageRegistry,
+		},
+		journal:  cfg.journal(),
+		notifier: n,
+	}, nil
+}
+
+func writeDriftReports(w io.Writer, format string, reports []*driftReport) error {
+	switch format {
+	case outputJSON:
+		data, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case outputYAML:
+		data, err := yaml.Marshal(reports)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	value := func(v interface{}, redacted bool) string {
+		if redacted {
+			return fmt.Sprintf("<redacted> (%s)", v)
+		}
+		return updater.FormatValue(v)
+	}
+
+	var b bytes.Buffer
+	for _, rep := range reports {
+		switch {
+		case rep.Error != "":
+			fmt.Fprintf(&b, "%s: error: %s\n", rep.Release, rep.Error)
+			continue
+		case !rep.drifted():
+			fmt.Fprintf(&b, "%s: in sync\n", rep.Release)
+			continue
+		case rep.FixedRevision != 0:
+			fmt.Fprintf(&b, "%s: drifted, fixed at revision %d\n", rep.Release, rep.FixedRevision)
+		default:
+			fmt.Fprintf(&b, "%s: drifted\n", rep.Release)
+		}
+
+		for _, v := range rep.Extra {
+			fmt.Fprintf(&b, "  extra      %s: %s\n", v.Key, value(v.Current, v.Redacted))
+		}
+		for _, v := range rep.Missing {
+			fmt.Fprintf(&b, "  missing    %s: %s\n", v.Key, value(v.Desired, v.Redacted))
+		}
+		for _, v := range rep.Different {
+			fmt.Fprintf(&b, "  different  %s: %s, desired %s\n", v.Key, value(v.Current, v.Redacted), value(v.Desired, v.Redacted))
+		}
+	}
+
+	_, err := b.WriteTo(w)
+	return err
+}
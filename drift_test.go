@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+)
+
+func TestCheckDrift(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := updater.NewRedactor(updater.RedactConfig{Key: []byte("test key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := newFakeBackend(fakeRelease("web", "prod", 3, "replicas: 1\nlogLevel: info\n", "replicas: 2\nextra: x\npassword: hunter22\n"))
+	file := writeTempFile(t, dir, "web.yaml", "replicas: 3\nlogLevel: info\npassword: hunter23\n")
+
+	rep, vals, err := checkDrift(backend, r, "web", file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Namespace != "prod" || rep.Revision != 3 {
+		t.Errorf("got namespace %s and revision %d, want prod and 3", rep.Namespace, rep.Revision)
+	}
+	if keys := driftKeys(rep.Extra); keys != "extra" {
+		t.Errorf("got extra keys %s", keys)
+	}
+	if keys := driftKeys(rep.Missing); keys != "logLevel" {
+		t.Errorf("got missing keys %s", keys)
+	}
+	if keys := driftKeys(rep.Different); keys != "password,replicas" {
+		t.Fatalf("got different keys %s", keys)
+	}
+	if p := rep.Different[0]; !p.Redacted || strings.Contains(updater.FormatValue(p), "hunter2") {
+		t.Errorf("password is not redacted: %+v", p)
+	}
+	if updater.FormatValue(vals) != `{"logLevel":"info","password":"hunter23","replicas":3}` {
+		t.Errorf("unexpected desired values %s", updater.FormatValue(vals))
+	}
+
+	// logLevel is at its default, which is no drift when the defaults of the
+	// chart are compared as well.
+	rep, _, err = checkDrift(backend, r, "web", file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := driftKeys(rep.Missing); keys != "" {
+		t.Errorf("got missing keys %s with --coalesced", keys)
+	}
+}
+
+func TestFixDriftChecksRevision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := updater.NewRedactor(updater.RedactConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{Journal: journalConfig{Disabled: true}}
+	backend := newFakeBackend(fakeRelease("web", "prod", 1, "", "replicas: 2\n"))
+	file := writeTempFile(t, dir, "web.yaml", "replicas: 3\n")
+
+	rep, vals, err := checkDrift(backend, r, "web", file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone updates the release after the drift was found.
+	backend.releases["web"] = append(backend.releases["web"], fakeRelease("web", "prod", 2, "", "replicas: 5\n"))
+
+	update, err := fixDriftCommand(cfg, backend, r, rep, vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := update.run(); err == nil {
+		t.Fatal("fix overwrote a newer revision")
+	} else if _, ok := err.(*updater.ConflictError); !ok {
+		t.Fatalf("got error %v, want a conflict", err)
+	}
+
+	rep, vals, err = checkDrift(backend, r, "web", file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update, err = fixDriftCommand(cfg, backend, r, rep, vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := update.run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Revision != 3 || string(res.Config) != "replicas: 3\n" {
+		t.Errorf("got revision %d with config %q", res.Revision, res.Config)
+	}
+}
+
+func driftKeys(values []driftValue) string {
+	var keys []string
+	for _, v := range values {
+		keys = append(keys, v.Key)
+	}
+	return strings.Join(keys, ",")
+}
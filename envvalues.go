@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/spf13/pflag"
+)
+
+// envFlags read values from environment variables and dotenv files. They are
+// applied in the order dotenv files, --env-prefix, --set-env, so each source
+// overrides the ones before it. --set overrides them all.
+type envFlags struct {
+	dotenvFiles []string
+	prefix      string
+	setEnv      []string
+}
+
+func (f *envFlags) register(fs *pflag.FlagSet) {
+	fs.StringArrayVar(&f.dotenvFiles, "values-dotenv", []string{}, "read values from a dotenv or properties file (can specify multiple)")
+	fs.StringVar(&f.prefix, "env-prefix", "", "read values from all environment variables with this prefix, e.g. HUC_IMAGE__TAG sets image.tag")
+	fs.StringArrayVar(&f.setEnv, "set-env", []string{}, "set a value from an environment variable (key=ENV_VAR, can specify multiple)")
+}
+
+// values returns the values of all environment sources merged.
+func (f *envFlags) values() (map[string]interface{}, error) {
+	vals := make(map[string]interface{})
+
+	for _, file := range f.dotenvFiles {
+		entries, err := readDotenvFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			path := e.key
+			if !strings.Contains(path, ".") {
+				path = envKeyPath(path)
+			}
+			updater.SetValue(vals, path, updater.ParseValue(e.value))
+		}
+	}
+
+	if f.prefix != "" {
+		var names []string
+		for _, kv := range os.Environ() {
+			name := kv[:strings.Index(kv, "=")]
+			if strings.HasPrefix(name, f.prefix) && len(name) > len(f.prefix) {
+				names = append(names, name)
+			}
+		}
+		// Sorted, so that the result does not depend on the order of the
+		// environment when two variables map to the same key.
+		sort.Strings(names)
+		for _, name := range names {
+			updater.SetValue(vals, envKeyPath(strings.TrimPrefix(name, f.prefix)), updater.ParseValue(os.Getenv(name)))
+		}
+	}
+
+	for _, s := range f.setEnv {
+		i := strings.Index(s, "=")
+		if i <= 0 || i == len(s)-1 {
+			return nil, fmt.Errorf("invalid --set-env %q: expected key=ENV_VAR", s)
+		}
+		v, ok := os.LookupEnv(s[i+1:])
+		if !ok {
+			return nil, fmt.Errorf("--set-env %s: environment variable %s is not set", s[:i], s[i+1:])
+		}
+		updater.SetValue(vals, s[:i], updater.ParseValue(v))
+	}
+
+	return vals, nil
+}
+
+// envKeyPath maps an environment variable name to a key path: "__" separates
+// levels and "_" within a level starts a new word in camel case, so
+// IMAGE__TAG is image.tag and REPLICA_COUNT is replicaCount.
+func envKeyPath(name string) string {
+	levels := strings.Split(name, "__")
+	for i, level := range levels {
+		words := strings.Split(strings.ToLower(level), "_")
+		for j := 1; j < len(words); j++ {
+			if words[j] != "" {
+				words[j] = strings.ToUpper(words[j][:1]) + words[j][1:]
+			}
+		}
+		levels[i] = strings.Join(words, "")
+	}
+	return strings.Join(levels, ".")
+}
+
+type dotenvEntry struct {
+	key   string
+	value string
+}
+
+var dotenvVarRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// readDotenvFile reads KEY=VALUE lines. Blank lines and lines starting with
+// # are skipped, an "export " prefix is ignored. Values may be quoted: double
+// quoted values understand \n, \" and \\ escapes, single quoted values are
+// taken literally. ${VAR} in unquoted and double quoted values is replaced by
+// an earlier key of the file or an environment variable, which has to exist.
+func readDotenvFile(filename string) ([]dotenvEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		entries []dotenvEntry
+		defined = make(map[string]string)
+		lineNo  int
+	)
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", filename, lineNo)
+		}
+		key := strings.TrimSpace(line[:i])
+		raw := strings.TrimSpace(line[i+1:])
+
+		var (
+			value  string
+			expand = true
+		)
+		switch {
+		case len(raw) >= 2 && raw[0] == '\'' && raw[len(raw)-1] == '\'':
+			value = raw[1 : len(raw)-1]
+			expand = false
+		case len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"':
+			value = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(raw[1 : len(raw)-1])
+		default:
+			value = raw
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = strings.TrimSpace(value[:j])
+			}
+		}
+
+		if expand {
+			var missing string
+			value = dotenvVarRe.ReplaceAllStringFunc(value, func(ref string) string {
+				name := ref[2 : len(ref)-1]
+				if v, ok := defined[name]; ok {
+					return v
+				}
+				if v, ok := os.LookupEnv(name); ok {
+					return v
+				}
+				if missing == "" {
+					missing = name
+				}
+				return ""
+			})
+			if missing != "" {
+				return nil, fmt.Errorf("%s:%d: variable %s is not set", filename, lineNo, missing)
+			}
+		}
+
+		defined[key] = value
+		entries = append(entries, dotenvEntry{key: key, value: value})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	return entries, nil
+}
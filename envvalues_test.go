@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+)
+
+func setenv(t *testing.T, vars map[string]string) func() {
+	t.Helper()
+	for k, v := range vars {
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for k := range vars {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func writeTempFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestEnvKeyPath(t *testing.T) {
+	tests := map[string]string{
+		"IMAGE__TAG":          "image.tag",
+		"REPLICA_COUNT":       "replicaCount",
+		"INGRESS__TLS_SECRET": "ingress.tlsSecret",
+		"A__B__C":             "a.b.c",
+		"TRAILING_":           "trailing",
+	}
+	for name, want := range tests {
+		if got := envKeyPath(name); got != want {
+			t.Errorf("envKeyPath(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestReadDotenvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer setenv(t, map[string]string{"HUC_TEST_HOST": "db.example.com"})()
+
+	filename := writeTempFile(t, dir, "values.env", `
+# a comment
+export IMAGE__TAG=v1.2.3
+USER=app # trailing comment
+URL=postgres://${USER}@${HUC_TEST_HOST}/app
+QUOTED="line one\nsays \"hi\" # not a comment"
+LITERAL='${USER} \n'
+db.port=5432
+`)
+
+	entries, err := readDotenvFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []dotenvEntry{
+		{"IMAGE__TAG", "v1.2.3"},
+		{"USER", "app"},
+		{"URL", "postgres://app@db.example.com/app"},
+		{"QUOTED", "line one\nsays \"hi\" # not a comment"},
+		{"LITERAL", `${USER} \n`},
+		{"db.port", "5432"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got %q, want %q", entries, want)
+	}
+
+	errs := map[string]string{
+		"missing.env": "A=${HUC_TEST_MISSING}\n",
+		"invalid.env": "A=1\nnot a pair\n",
+	}
+	wantErrs := map[string]string{
+		"missing.env": "missing.env:1: variable HUC_TEST_MISSING is not set",
+		"invalid.env": "invalid.env:2: expected KEY=VALUE",
+	}
+	for name, content := range errs {
+		_, err := readDotenvFile(writeTempFile(t, dir, name, content))
+		if err == nil || !strings.HasSuffix(err.Error(), wantErrs[name]) {
+			t.Errorf("%s: got error %v, want %q", name, err, wantErrs[name])
+		}
+	}
+}
+
+func TestEnvFlagsValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer setenv(t, map[string]string{
+		"HUC_IMAGE__TAG":     "from-prefix",
+		"HUC_REPLICA_COUNT":  "3",
+		"HUC_TEST_DEBUG":     "true",
+		"HUC_TEST_LOG_LEVEL": "debug",
+	})()
+
+	f := envFlags{
+		dotenvFiles: []string{writeTempFile(t, dir, "values.env", "IMAGE__TAG=from-file\nIMAGE__REPOSITORY=nginx\n")},
+		prefix:      "HUC_",
+		setEnv:      []string{"debug=HUC_TEST_DEBUG"},
+	}
+	// Later sources override earlier ones, and the prefix catches the
+	// variables meant for --set-env as well.
+	vals, err := f.values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := updater.FormatValue(vals)
+	if want := `{"debug":true,"image":{"repository":"nginx","tag":"from-prefix"},"replicaCount":3,"testDebug":true,"testLogLevel":"debug"}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	f.setEnv = []string{"image.tag=HUC_TEST_UNSET"}
+	if _, err := f.values(); err == nil || !strings.Contains(err.Error(), "HUC_TEST_UNSET is not set") {
+		t.Errorf("got error %v for an unset variable", err)
+	}
+	f.setEnv = []string{"image.tag"}
+	if _, err := f.values(); err == nil || !strings.Contains(err.Error(), "expected key=ENV_VAR") {
+		t.Errorf("got error %v for an invalid --set-env", err)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/spf13/cobra"
+)
+
+// journalConfig configures the local audit journal.
+type journalConfig struct {
+	// File is where records are appended. It defaults to a file in the Helm home.
+	File     string `json:"file"`
+	Disabled bool   `json:"disabled"`
+}
+
+func (c journalConfig) file() string {
+	if c.File != "" {
+		return c.File
+	}
+	return filepath.Join(helmHome(), "update-config", "journal.jsonl")
+}
+
+// journalEntry is a single line of the journal.
+type journalEntry struct {
+	Time      time.Time `json:"time"`
+	Operator  operator  `json:"operator"`
+	Release   string    `json:"release"`
+	Namespace string    `json:"namespace,omitempty"`
+	// Action is apply-plan or clone for changes not made by update-config
+	// itself, and Source the plan file applied or the release cloned.
+	// ValuesDotenv, EnvPrefix and SetEnv name the environment sources of
+	// values; the values read from them show in Diff.
+	Action       string           `json:"action,omitempty"`
+	Source       string           `json:"source,omitempty"`
+	BaseRevision int32            `json:"baseRevision,omitempty"`
+	NewRevision  int32            `json:"newRevision,omitempty"`
+	Overrides    []string         `json:"overrides,omitempty"`
+	SetTemplates []string         `json:"setTemplates,omitempty"`
+	ValuesDotenv []string         `json:"valuesDotenv,omitempty"`
+	EnvPrefix    string           `json:"envPrefix,omitempty"`
+	SetEnv       []string         `json:"setEnv,omitempty"`
+	IfValues     []string         `json:"if,omitempty"`
+	IfAbsent     []string         `json:"ifAbsent,omitempty"`
+	PatchFiles   []string         `json:"patchFiles,omitempty"`
+	ResetValues  bool             `json:"resetValues,omitempty"`
+	Diff         []updater.Change `json:"diff,omitempty"`
+	Outcome      string           `json:"outcome"`
+	Error        string           `json:"error,omitempty"`
+	Duration     float64          `json:"durationSeconds"`
+}
+
+// operator identifies who ran the command and where.
+type operator struct {
+	User     string `json:"user"`
+	KubeUser string `json:"kubeUser,omitempty"`
+	Host     string `json:"host"`
+	// Token is the name of the API token of changes made through serve.
+	Token string `json:"token,omitempty"`
+}
+
+const (
+	outcomeSuccess            = "success"
+	outcomeFailure            = "failure"
+	outcomePreconditionFailed = "precondition-failed"
+)
+
+func outcomeOf(err error) string {
+	switch {
+	case err == nil:
+		return outcomeSuccess
+	case exitCode(err) == exitPreconditionFailed:
+		return outcomePreconditionFailed
+	}
+	return outcomeFailure
+}
+
+// currentUser returns the name of the user running the command.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+func currentOperator() operator {
+	op := operator{User: currentUser()}
+	op.Host, _ = os.Hostname()
+
+	if kc, err := loadKubeConfig(kubeConfigFile); err == nil {
+		if ctx, err := kc.context(kubeContextName); err == nil {
+			op.KubeUser = ctx.User
+		}
+	}
+
+	return op
+}
+
+type journal struct {
+	file string
+}
+
+// newJournalEntry describes an update attempt.
+func newJournalEntry(cmd *updateConfigCommand, res *updater.Result, err error, d time.Duration) journalEntry {
+	entry := journalEntry{
+		Time:        time.Now().UTC(),
+		Operator:    cmd.operator(),
+		Release:     cmd.opts.Release,
+		Action:      cmd.action,
+		Source:      cmd.source,
+		PatchFiles:  cmd.patchFiles,
+		ResetValues: cmd.opts.ResetValues,
+		Outcome:     outcomeOf(err),
+		Duration:    d.Seconds(),
+	}
+	entry.ValuesDotenv, entry.EnvPrefix, entry.SetEnv = cmd.env.dotenvFiles, cmd.env.prefix, cmd.env.setEnv
+
+	r := cmd.opts.Redactor
+	for _, o := range cmd.opts.Set {
+		entry.Overrides = append(entry.Overrides, r.SetFlag(o))
+	}
+	for _, t := range cmd.opts.SetTemplates {
+		entry.SetTemplates = append(entry.SetTemplates, r.SetFlag(t))
+	}
+	for _, p := range cmd.opts.Preconditions {
+		if p.Absent {
+			entry.IfAbsent = append(entry.IfAbsent, p.Path)
+			continue
+		}
+		entry.IfValues = append(entry.IfValues, p.Path+"="+updater.FormatValue(r.Value(p.Path, p.Value)))
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	if res != nil {
+		entry.Namespace = res.Namespace
+		entry.BaseRevision = res.PreviousRevision
+		entry.NewRevision = res.Revision
+		entry.Diff = res.Changes
+	}
+
+	return entry
+}
+
+// record appends an entry to the journal. Failing to write the journal does
+// not fail the update, it is reported on stderr instead.
+func (j *journal) record(entry journalEntry) {
+	if err := j.write(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: could not write journal: %s\n", err)
+	}
+}
+
+func (j *journal) write(entry journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.file), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// journalFilter selects journal entries. Zero fields match everything.
+type journalFilter struct {
+	release string
+	user    string
+	since   time.Time
+	until   time.Time
+}
+
+func (f journalFilter) match(e journalEntry) bool {
+	if f.release != "" && e.Release != f.release {
+		return false
+	}
+	if f.user != "" && e.Operator.User != f.user && e.Operator.KubeUser != f.user {
+		return false
+	}
+	if !f.since.IsZero() && e.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && e.Time.After(f.until) {
+		return false
+	}
+	return true
+}
+
+func (j *journal) query(f journalFilter) ([]journalEntry, error) {
+	file, err := os.Open(j.file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []journalEntry
+
+	s := bufio.NewScanner(file)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var e journalEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", j.file, line, err)
+		}
+		if f.match(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, s.Err()
+}
+
+// parseTimeFlag accepts an RFC 3339 timestamp, a date, or a duration which is
+// taken relative to now (e.g. 24h means 24 hours ago).
+func parseTimeFlag(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use RFC 3339, YYYY-MM-DD or a duration", s)
+}
+
+func newJournalCmd() *cobra.Command {
+	var (
+		filter     journalFilter
+		since      string
+		until      string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "journal [flags]",
+		Short: "show the local journal of config changes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if filter.since, err = parseTimeFlag(since); err != nil {
+				return err
+			}
+			if filter.until, err = parseTimeFlag(until); err != nil {
+				return err
+			}
+
+			cfg, err := readConfig()
+			if err != nil {
+				return err
+			}
+
+			j := cfg.journal()
+			if j == nil {
+				return errors.New("the journal is disabled in the config file")
+			}
+
+			entries, err := j.query(filter)
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				for _, e := range entries {
+					if err := enc.Encode(e); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "TIME\tUSER\tRELEASE\tREVISION\tOUTCOME\tDURATION\tCHANGES")
+			for _, e := range entries {
+				rev := fmt.Sprintf("%d", e.BaseRevision)
+				if e.NewRevision != 0 {
+					rev = fmt.Sprintf("%d -> %d", e.BaseRevision, e.NewRevision)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%.1fs\t%d\n",
+					e.Time.Local().Format(time.RFC3339), e.Operator.User, e.Release, rev, e.Outcome, e.Duration, len(e.Diff))
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&filter.release, "release", "", "only show changes of this release")
+	cmd.Flags().StringVar(&filter.user, "user", "", "only show changes made by this OS or kube user")
+	cmd.Flags().StringVar(&since, "since", "", "only show changes after this time (RFC 3339, YYYY-MM-DD or a duration like 24h)")
+	cmd.Flags().StringVar(&until, "until", "", "only show changes before this time (RFC 3339, YYYY-MM-DD or a duration like 24h)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "print matching records as JSON lines")
+
+	return cmd
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+)
+
+func TestApplyPlanIsJournaled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keys := filepath.Join(dir, "keys")
+	if err := os.Mkdir(keys, 0755); err != nil {
+		t.Fatal(err)
+	}
+	alice := writeTrustedKey(t, keys, "alice")
+	bob := writeTrustedKey(t, keys, "bob")
+
+	cfg := &config{
+		Approvals: approvalConfig{TrustedKeysDir: keys},
+		Journal:   journalConfig{File: filepath.Join(dir, "journal.jsonl")},
+	}
+	backend := newFakeBackend(fakeRelease("web", "prod", 1, "", "a: 1\n"))
+
+	newPlan := func(signers ...ed25519.PrivateKey) *plan {
+		p := &plan{Release: "web", Namespace: "prod", BaseRevision: 1, Author: "alice", NewConfig: "a: 2\n"}
+		if err := p.signAsAuthor(alice); err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range signers {
+			if err := p.sign("bob", k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return p
+	}
+
+	if err := applyPlan(cfg, backend, newPlan(), "unsigned.json"); err == nil {
+		t.Fatal("unsigned plan was applied")
+	}
+	if err := applyPlan(cfg, backend, newPlan(bob), "plan.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := cfg.journal().query(journalFilter{release: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d journal entries, want 2", len(entries))
+	}
+
+	failed, applied := entries[0], entries[1]
+	if failed.Outcome != outcomeFailure || failed.Source != "unsigned.json" || failed.Error == "" {
+		t.Errorf("unexpected entry of the unsigned plan: %+v", failed)
+	}
+	if applied.Outcome != outcomeSuccess || applied.Action != "apply-plan" || applied.Source != "plan.json" ||
+		applied.Namespace != "prod" || applied.BaseRevision != 1 || applied.NewRevision != 2 {
+		t.Errorf("unexpected entry of the applied plan: %+v", applied)
+	}
+}
+
+func TestJournalEntryOverrides(t *testing.T) {
+	r, err := updater.NewRedactor(updater.RedactConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := &updateConfigCommand{
+		opts: updater.Options{
+			Release:      "web",
+			Set:          []string{"replicas=2,db.password=hunter22"},
+			SetTemplates: []string{"replicas={{ add .Values.replicas 1 }}", "apiToken={{ .Values.token }}"},
+			Preconditions: []updater.Precondition{
+				{Path: "image.tag", Value: "v1"},
+				{Path: "db.password", Value: "hunter2"},
+				{Path: "image.digest", Absent: true},
+			},
+			Redactor: r,
+		},
+		env: envFlags{dotenvFiles: []string{"prod.env"}, prefix: "HUC_", setEnv: []string{"db.password=DB_PASSWORD"}},
+	}
+
+	entry := newJournalEntry(cmd, nil, nil, time.Second)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Errorf("password leaked into the journal: %s", data)
+	}
+
+	tests := []struct {
+		field string
+		got   interface{}
+		want  string
+	}{
+		{"overrides", entry.Overrides, "[replicas=2,db.password=<redacted>]"},
+		{"setTemplates", entry.SetTemplates, "[replicas={{ add .Values.replicas 1 }} apiToken=<redacted>]"},
+		{"valuesDotenv", entry.ValuesDotenv, "[prod.env]"},
+		{"envPrefix", entry.EnvPrefix, "HUC_"},
+		{"setEnv", entry.SetEnv, "[db.password=DB_PASSWORD]"},
+		{"if", entry.IfValues, "[image.tag=v1 db.password=<redacted>]"},
+		{"ifAbsent", entry.IfAbsent, "[image.digest]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(tt.got); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.field, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// kubeConfig is the subset of a kubeconfig file the plugin needs.
+type kubeConfig struct {
+	CurrentContext string             `json:"current-context"`
+	Contexts       []namedKubeContext `json:"contexts"`
+	Clusters       []namedKubeCluster `json:"clusters"`
+	Users          []namedKubeUser    `json:"users"`
+
+	// dir is the directory of the file, relative paths are resolved against it.
+	dir string
+}
+
+type namedKubeContext struct {
+	Name    string      `json:"name"`
+	Context kubeContext `json:"context"`
+}
+
+type kubeContext struct {
+	Cluster   string `json:"cluster"`
+	User      string `json:"user"`
+	Namespace string `json:"namespace"`
+}
+
+type namedKubeCluster struct {
+	Name    string      `json:"name"`
+	Cluster kubeCluster `json:"cluster"`
+}
+
+type kubeCluster struct {
+	Server                   string `json:"server"`
+	CertificateAuthority     string `json:"certificate-authority"`
+	CertificateAuthorityData []byte `json:"certificate-authority-data"`
+	InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+}
+
+type namedKubeUser struct {
+	Name string   `json:"name"`
+	User kubeUser `json:"user"`
+}
+
+type kubeUser struct {
+	ClientCertificate     string          `json:"client-certificate"`
+	ClientCertificateData []byte          `json:"client-certificate-data"`
+	ClientKey             string          `json:"client-key"`
+	ClientKeyData         []byte          `json:"client-key-data"`
+	Token                 string          `json:"token"`
+	TokenFile             string          `json:"tokenFile"`
+	Username              string          `json:"username"`
+	Password              string          `json:"password"`
+	Exec                  *kubeExecConfig `json:"exec"`
+}
+
+// kubeExecConfig runs a credential plugin, as used by EKS, GKE and others.
+type kubeExecConfig struct {
+	APIVersion string   `json:"apiVersion"`
+	Command    string   `json:"command"`
+	Args       []string `json:"args"`
+	Env        []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"env"`
+}
+
+// kubeConfigPath returns the kubeconfig file kubectl would use.
+func kubeConfigPath() string {
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		return filepath.SplitList(env)[0]
+	}
+	return filepath.Join(os.Getenv("HOME"), ".kube", "config")
+}
+
+func loadKubeConfig(filename string) (*kubeConfig, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var c kubeConfig
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	c.dir = filepath.Dir(filename)
+
+	return &c, nil
+}
+
+// context returns the named context, or the current one if name is empty.
+func (c *kubeConfig) context(name string) (*kubeContext, error) {
+	if name == "" {
+		name = c.CurrentContext
+	}
+
+	for _, ctx := range c.Contexts {
+		if ctx.Name == name {
+			return &ctx.Context, nil
+		}
+	}
+
+	return nil, fmt.Errorf("context %q not found in kubeconfig", name)
+}
+
+func (c *kubeConfig) cluster(name string) (*kubeCluster, error) {
+	for _, cl := range c.Clusters {
+		if cl.Name == name {
+			return &cl.Cluster, nil
+		}
+	}
+	return nil, fmt.Errorf("cluster %q not found in kubeconfig", name)
+}
+
+func (c *kubeConfig) user(name string) *kubeUser {
+	for _, u := range c.Users {
+		if u.Name == name {
+			return &u.User
+		}
+	}
+	return &kubeUser{}
+}
+
+func (c *kubeConfig) readFile(name string) ([]byte, error) {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(c.dir, name)
+	}
+	return ioutil.ReadFile(name)
+}
+
+// kubeClient is a minimal client for the Kubernetes REST API.
+type kubeClient struct {
+	server    string
+	tls       *tls.Config
+	http      *http.Client
+	token     string
+	username  string
+	password  string
+	namespace string
+	// proxy selects the proxy for a request, for REST calls and websockets.
+	proxy func(*http.Request) (*url.URL, error)
+}
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubeClientTimeout = 30 * time.Second
+)
+
+// newKubeClient creates a client for the given context of a kubeconfig file.
+// Without a kubeconfig file inside a pod, the pod's service account is used.
+func newKubeClient(kubeconfig, context string) (*kubeClient, error) {
+	if _, err := os.Stat(kubeconfig); os.IsNotExist(err) && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return inClusterKubeClient()
+	}
+
+	kc, err := loadKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := kc.context(context)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, err := kc.cluster(ctx.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	user := kc.user(ctx.User)
+
+	c := &kubeClient{
+		server:    strings.TrimSuffix(cluster.Server, "/"),
+		tls:       &tls.Config{InsecureSkipVerify: cluster.InsecureSkipTLSVerify},
+		proxy:     http.ProxyFromEnvironment,
+		token:     user.Token,
+		username:  user.Username,
+		password:  user.Password,
+		namespace: ctx.Namespace,
+	}
+
+	ca := cluster.CertificateAuthorityData
+	if len(ca) == 0 && cluster.CertificateAuthority != "" {
+		if ca, err = kc.readFile(cluster.CertificateAuthority); err != nil {
+			return nil, err
+		}
+	}
+	if len(ca) > 0 {
+		c.tls.RootCAs = x509.NewCertPool()
+		if !c.tls.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid certificate authority for cluster %q", ctx.Cluster)
+		}
+	}
+
+	if c.token == "" && user.TokenFile != "" {
+		data, err := kc.readFile(user.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		c.token = strings.TrimSpace(string(data))
+	}
+
+	cert, key := user.ClientCertificateData, user.ClientKeyData
+	if len(cert) == 0 && user.ClientCertificate != "" {
+		if cert, err = kc.readFile(user.ClientCertificate); err != nil {
+			return nil, err
+		}
+	}
+	if len(key) == 0 && user.ClientKey != "" {
+		if key, err = kc.readFile(user.ClientKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if user.Exec != nil {
+		cred, err := runExecCredential(user.Exec)
+		if err != nil {
+			return nil, err
+		}
+		if cred.Token != "" {
+			c.token = cred.Token
+		}
+		if cred.ClientCertificateData != "" {
+			cert, key = []byte(cred.ClientCertificateData), []byte(cred.ClientKeyData)
+		}
+	}
+
+	if len(cert) > 0 {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		c.tls.Certificates = []tls.Certificate{pair}
+	}
+
+	c.http = &http.Client{
+		Timeout:   kubeClientTimeout,
+		Transport: &http.Transport{TLSClientConfig: c.tls, Proxy: c.proxy},
+	}
+
+	return c, nil
+}
+
+func inClusterKubeClient() (*kubeClient, error) {
+	token, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	ns, _ := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+
+	c := &kubeClient{
+		server:    "https://" + os.Getenv("KUBERNETES_SERVICE_HOST") + ":" + os.Getenv("KUBERNETES_SERVICE_PORT"),
+		tls:       &tls.Config{RootCAs: x509.NewCertPool()},
+		token:     strings.TrimSpace(string(token)),
+		namespace: strings.TrimSpace(string(ns)),
+	}
+	c.tls.RootCAs.AppendCertsFromPEM(ca)
+	c.http = &http.Client{
+		Timeout:   kubeClientTimeout,
+		Transport: &http.Transport{TLSClientConfig: c.tls},
+	}
+
+	return c, nil
+}
+
+type execCredentialStatus struct {
+	Token                 string `json:"token"`
+	ClientCertificateData string `json:"clientCertificateData"`
+	ClientKeyData         string `json:"clientKeyData"`
+}
+
+func runExecCredential(cfg *kubeExecConfig) (*execCredentialStatus, error) {
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Env = os.Environ()
+	for _, e := range cfg.Env {
+		cmd.Env = append(cmd.Env, e.Name+"="+e.Value)
+	}
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running credential plugin %s: %s", cfg.Command, err)
+	}
+
+	var cred struct {
+		Status execCredentialStatus `json:"status"`
+	}
+	if err := json.Unmarshal(out, &cred); err != nil {
+		return nil, fmt.Errorf("reading credentials from %s: %s", cfg.Command, err)
+	}
+
+	return &cred.Status, nil
+}
+
+// kubeAPIError is a non-successful response of the Kubernetes API.
+type kubeAPIError struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e *kubeAPIError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("kubernetes API: %d %s", e.Code, http.StatusText(e.Code))
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*kubeAPIError)
+	return ok && e.Code == http.StatusNotFound
+}
+
+func (c *kubeClient) authorize(req *http.Request) {
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.username != "":
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// do sends a request to the API. in and out are encoded as JSON, either may be nil.
+func (c *kubeClient) do(method, path string, query url.Values, in, out interface{}) error {
+	u := c.server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.authorize(req)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &kubeAPIError{}
+		json.Unmarshal(data, e)
+		e.Code = resp.StatusCode
+		return e
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, out)
+}
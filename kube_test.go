@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewKubeClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer s3cr3t" {
+			http.Error(w, `{"message": "unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"path": %q}`, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca.crt"), ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "token"), []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Relative files are resolved against the directory of the kubeconfig.
+	kubeconfig := filepath.Join(dir, "config")
+	err = ioutil.WriteFile(kubeconfig, []byte(`
+current-context: other
+contexts:
+- name: test
+  context: {cluster: test, user: test, namespace: web}
+- name: other
+  context: {cluster: missing, user: test}
+clusters:
+- name: test
+  cluster: {server: "`+srv.URL+`/", certificate-authority: ca.crt}
+users:
+- name: test
+  user: {tokenFile: token}
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newKubeClient(kubeconfig, ""); err == nil || !strings.Contains(err.Error(), `cluster "missing" not found`) {
+		t.Errorf("got error %v for the current context", err)
+	}
+	if _, err := newKubeClient(kubeconfig, "nope"); err == nil || !strings.Contains(err.Error(), `context "nope" not found`) {
+		t.Errorf("got error %v for a missing context", err)
+	}
+
+	c, err := newKubeClient(kubeconfig, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.namespace != "web" {
+		t.Errorf("got namespace %q", c.namespace)
+	}
+
+	var out struct {
+		Path string `json:"path"`
+	}
+	if err := c.do("GET", "/api/v1/namespaces", nil, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Path != "/api/v1/namespaces" {
+		t.Errorf("got path %q", out.Path)
+	}
+}
+
+func TestKubeClientDo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "pw" {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind": "Status", "reason": "NotFound", "message": "secrets \"x\" not found", "code": 404}`)
+		case "/echo":
+			if r.Header.Get("Content-Type") != "application/json" {
+				http.Error(w, "", http.StatusUnsupportedMediaType)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			fmt.Fprintf(w, `{"query": %q, "body": %s}`, r.URL.RawQuery, body)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	c := &kubeClient{server: srv.URL, http: srv.Client(), username: "admin", password: "pw"}
+
+	var out struct {
+		Query string            `json:"query"`
+		Body  map[string]string `json:"body"`
+	}
+	err := c.do("POST", "/echo", map[string][]string{"labelSelector": {"owner=helm"}}, map[string]string{"a": "b"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Query != "labelSelector=owner%3Dhelm" || out.Body["a"] != "b" {
+		t.Errorf("unexpected request %+v", out)
+	}
+
+	err = c.do("GET", "/missing", nil, nil, nil)
+	if !isNotFound(err) || err.Error() != `secrets "x" not found` {
+		t.Errorf("got error %v, want not found", err)
+	}
+
+	err = c.do("GET", "/fail", nil, nil, nil)
+	if isNotFound(err) || err == nil || err.Error() != "kubernetes API: 500 Internal Server Error" {
+		t.Errorf("got error %v, want a server error", err)
+	}
+}
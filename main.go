@@ -1,18 +1,21 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/burdiyan/helm-update-config/pkg/updater"
 	"github.com/spf13/cobra"
-	yaml "gopkg.in/yaml.v1"
-	"k8s.io/helm/pkg/helm"
-	"k8s.io/helm/pkg/strvals"
+	"github.com/spf13/pflag"
 )
 
 func main() {
 	var (
-		cliValues   []string
-		resetValues bool
+		flags updateFlags
+		apply applyFlags
 	)
 
 	cmd := &cobra.Command{
@@ -20,63 +23,280 @@ func main() {
 		Short: "update config values of an existing release",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			vals := make(map[string]interface{})
-			for _, v := range cliValues {
-				if err := strvals.ParseInto(v, vals); err != nil {
-					return err
-				}
+			cfg, err := readConfig()
+			if err != nil {
+				return err
 			}
 
-			update := updateConfigCommand{
-				client:      helm.NewClient(helm.Host(os.Getenv("TILLER_HOST"))),
-				release:     args[0],
-				values:      vals,
-				resetValues: resetValues,
+			backend, err := newBackend()
+			if err != nil {
+				return err
 			}
+			defer backend.Close()
 
-			return update.run()
+			if err := apply.check(backend); err != nil {
+				return err
+			}
+
+			update, err := flags.command(cfg, backend, args[0])
+			if err != nil {
+				return err
+			}
+
+			return apply.apply(backend, update)
 		},
 	}
 
-	cmd.Flags().StringArrayVar(&cliValues, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
-	cmd.Flags().BoolVar(&resetValues, "reset-values", false, "when upgrading, reset the values to the ones built into the chart")
+	flags.register(cmd.Flags())
+	apply.register(cmd.Flags())
+	cmd.PersistentFlags().StringVar(&configFile, "config", defaultConfigFile(), "path to the plugin config file")
+	cmd.PersistentFlags().StringVar(&backendName, "backend", backendTiller, "where releases are stored: tiller for Helm 2, secret or configmap for Helm 3")
+	cmd.PersistentFlags().StringVar(&kubeConfigFile, "kubeconfig", kubeConfigPath(), "path to the kubeconfig file")
+	cmd.PersistentFlags().StringVar(&kubeContextName, "kube-context", os.Getenv("HELM_KUBECONTEXT"), "name of the kubeconfig context to use")
+	cmd.PersistentFlags().StringVar(&tillerNamespace, "tiller-namespace", defaultTillerNamespace(), "namespace of Tiller, used when TILLER_HOST is not set")
+	cmd.PersistentFlags().StringVar(&namespace, "namespace", "", "namespace of the release, all namespaces are searched if empty (Helm 3 backends), or of the new release of clone")
+	cmd.PersistentFlags().StringVar(&journalFile, "journal-file", "", "file to record config changes in (overrides the config file)")
+	cmd.PersistentFlags().StringArrayVar(&notifyURLs, "notify-url", []string{}, "webhook to notify of every update, in addition to the config file (can specify multiple)")
+	cmd.PersistentFlags().StringVar(&notifyFormat, "notify-format", notifyFormatJSON, "body of the --notify-url webhooks: json, slack, discord or teams")
 
-	if err := cmd.Execute(); err != nil {
-		os.Exit(1)
+	cmd.AddCommand(
+		newPlanCmd(),
+		newSignPlanCmd(),
+		newApplyPlanCmd(),
+		newJournalCmd(),
+		newBumpCmd(),
+		newPromoteCmd(),
+		newCloneCmd(),
+		newSearchCmd(),
+		newMatrixCmd(),
+		newDriftCmd(),
+		newReconcileCmd(),
+		newServeCmd(),
+	)
+
+	err := cmd.Execute()
+	if !waitNotifications(notifyDeadline) {
+		fmt.Fprintf(os.Stderr, "WARNING: gave up waiting for webhook notifications after %s\n", notifyDeadline)
+	}
+	if err != nil {
+		os.Exit(exitCode(err))
 	}
 }
 
-type updateConfigCommand struct {
-	client      helm.Interface
-	release     string
-	values      map[string]interface{}
-	resetValues bool
+const (
+	exitError              = 1
+	exitDrift              = 2
+	exitPreconditionFailed = 3
+)
+
+func exitCode(err error) int {
+	switch err.(type) {
+	case *updater.ConflictError:
+		return exitPreconditionFailed
+	case *driftError:
+		return exitDrift
+	}
+
+	return exitError
+}
+
+// patchFile is a --patch-file or --merge-patch-file.
+type patchFile struct {
+	name  string
+	merge bool
 }
 
-func (cmd *updateConfigCommand) run() error {
-	res, err := cmd.client.ReleaseContent(cmd.release)
+// patchFileFlag collects both kinds of patch files into one list, so that
+// they are applied in the order they were given.
+type patchFileFlag struct {
+	files *[]patchFile
+	merge bool
+}
+
+func (f *patchFileFlag) String() string {
+	var names []string
+	for _, pf := range *f.files {
+		if pf.merge == f.merge {
+			names = append(names, pf.name)
+		}
+	}
+	return "[" + strings.Join(names, ",") + "]"
+}
+
+func (f *patchFileFlag) Set(name string) error {
+	*f.files = append(*f.files, patchFile{name: name, merge: f.merge})
+	return nil
+}
+
+func (f *patchFileFlag) Type() string {
+	return "stringArray"
+}
+
+// updateFlags are the flags describing a config change, shared by every
+// command that makes one.
+type updateFlags struct {
+	values         []string
+	templates      []string
+	patchFiles     []patchFile
+	ifValues       []string
+	ifAbsent       []string
+	resetValues    bool
+	pinDigests     bool
+	imagePaths     []string
+	skipImageCheck bool
+	env            envFlags
+}
+
+func (f *updateFlags) register(fs *pflag.FlagSet) {
+	fs.StringArrayVar(&f.values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	fs.StringArrayVar(&f.templates, "set-tpl", []string{}, "set a value computed by a template from the current values, e.g. replicaCount='{{ add .Values.replicaCount 2 }}' (can specify multiple)")
+	fs.Var(&patchFileFlag{files: &f.patchFiles}, "patch-file", "apply an RFC 6902 JSON Patch file to the current release config (can specify multiple, applied in the order given)")
+	fs.Var(&patchFileFlag{files: &f.patchFiles, merge: true}, "merge-patch-file", "apply an RFC 7386 JSON Merge Patch file to the current release config (can specify multiple, applied in the order given)")
+	fs.StringArrayVar(&f.ifValues, "if", []string{}, "only update if the current value at path equals value (path=value, can specify multiple)")
+	fs.StringArrayVar(&f.ifAbsent, "if-absent", []string{}, "only update if no value is set at path (can specify multiple)")
+	fs.BoolVar(&f.resetValues, "reset-values", false, "when upgrading, reset the values to the ones built into the chart")
+	fs.BoolVar(&f.pinDigests, "pin-digests", false, "resolve the tags of the images in the new values to registry digests and set tag@digest")
+	fs.StringArrayVar(&f.imagePaths, "image-path", []string{}, "where --pin-digests finds an image: the path of a complete reference or repository=path,tag=path (can specify multiple, default: every image.repository and image.tag)")
+	fs.BoolVar(&f.skipImageCheck, "skip-image-check", false, "do not check that the images of the new manifest exist in their registries")
+	f.env.register(fs)
+}
+
+func (f *updateFlags) command(cfg *config, backend releaseBackend, release string) (*updateConfigCommand, error) {
+	r, err := cfg.redactor()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	rawVals, err := yaml.Marshal(cmd.values)
+	vals, err := f.env.values()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	var opt helm.UpdateOption
-	if cmd.resetValues {
-		opt = helm.ResetValues(true)
-	} else {
-		opt = helm.ReuseValues(true)
+	opts := updater.Options{
+		Release:      release,
+		Values:       vals,
+		Set:          f.values,
+		SetTemplates: f.templates,
+		ResetValues:  f.resetValues,
+		Redactor:     r,
+		PinDigests:   f.pinDigests,
+		ImagePaths:   cfg.Images.Paths,
+		CheckImages:  !f.skipImageCheck,
+		Registry:     imageRegistry,
 	}
 
-	_, err = cmd.client.UpdateReleaseFromChart(
-		cmd.release,
-		res.Release.Chart,
-		helm.UpdateValueOverrides(rawVals),
-		opt,
-	)
+	if len(f.imagePaths) > 0 {
+		opts.ImagePaths = nil
+		for _, s := range f.imagePaths {
+			p, err := updater.ParseImagePath(s)
+			if err != nil {
+				return nil, err
+			}
+			opts.ImagePaths = append(opts.ImagePaths, p)
+		}
+	}
+
+	var patchFiles []string
+	for _, pf := range f.patchFiles {
+		read := updater.ReadJSONPatchFile
+		if pf.merge {
+			read = updater.ReadMergePatchFile
+		}
+		p, err := read(pf.name)
+		if err != nil {
+			return nil, err
+		}
+		opts.Patches = append(opts.Patches, p)
+		patchFiles = append(patchFiles, pf.name)
+	}
+
+	for _, v := range f.ifValues {
+		c, err := updater.ParsePrecondition(v)
+		if err != nil {
+			return nil, err
+		}
+		opts.Preconditions = append(opts.Preconditions, c)
+	}
+	for _, p := range f.ifAbsent {
+		opts.Preconditions = append(opts.Preconditions, updater.Precondition{Path: p, Absent: true})
+	}
+
+	n, err := cfg.notifier()
+	if err != nil {
+		return nil, err
+	}
+
+	return &updateConfigCommand{
+		backend:    backend,
+		opts:       opts,
+		patchFiles: patchFiles,
+		env:        f.env,
+		journal:    cfg.journal(),
+		notifier:   n,
+	}, nil
+}
+
+// imageRegistry is shared by all updates of a run, so every image is only
+// looked up once.
+var imageRegistry = updater.NewRegistry()
+
+// updateConfigCommand runs an update with the updater package, records it
+// in the journal and notifies the webhooks.
+type updateConfigCommand struct {
+	backend    releaseBackend
+	opts       updater.Options
+	patchFiles []string
+	journal    *journal
+	notifier   *notifier
+	// token is the name of the API token the update was requested with.
+	token string
+	// action and source describe changes made by other commands than
+	// update-config, see journalEntry.
+	action string
+	source string
+	// env are the environment sources of opts.Values, for the journal.
+	env envFlags
+}
+
+// run makes the update and records it.
+func (cmd *updateConfigCommand) run() (*updater.Result, error) {
+	start := time.Now()
+
+	res, err := cmd.update()
+	cmd.record(res, err, time.Since(start))
+
+	return res, err
+}
+
+// update makes the update without recording it, for callers that record the
+// outcome of what follows the update as well.
+func (cmd *updateConfigCommand) update() (*updater.Result, error) {
+	res, err := updater.UpdateBackend(context.Background(), cmd.backend, cmd.opts)
+	if res != nil {
+		printWarnings(res.Warnings)
+	}
+	return res, err
+}
+
+// record journals an update attempt and notifies the webhooks of it. Dry runs
+// are not recorded.
+func (cmd *updateConfigCommand) record(res *updater.Result, err error, d time.Duration) {
+	if cmd.opts.DryRun || (cmd.journal == nil && cmd.notifier == nil) {
+		return
+	}
+
+	entry := newJournalEntry(cmd, res, err, d)
+	if cmd.journal != nil {
+		cmd.journal.record(entry)
+	}
+	if cmd.notifier != nil {
+		cmd.notifier.notify(entry)
+	}
+}
 
-	return err
+// operator identifies who made the update.
+func (cmd *updateConfigCommand) operator() operator {
+	op := currentOperator()
+	op.Token = cmd.token
+	return op
 }
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestPatchFilesKeepOrder(t *testing.T) {
+	var f updateFlags
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	f.register(fs)
+
+	err := fs.Parse([]string{"--merge-patch-file", "a.yaml", "--patch-file", "b.json", "--merge-patch-file", "c.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []patchFile{{name: "a.yaml", merge: true}, {name: "b.json"}, {name: "c.yaml", merge: true}}
+	if !reflect.DeepEqual(f.patchFiles, want) {
+		t.Errorf("got %v, want %v", f.patchFiles, want)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// serverMetrics are the metrics of serve, exported in the Prometheus text
+// format.
+type serverMetrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]int64
+	durations map[string]*durationSummary
+	updates   map[string]int64
+	inFlight  int64
+}
+
+type requestKey struct {
+	handler string
+	code    int
+}
+
+type durationSummary struct {
+	sum   float64
+	count int64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests:  make(map[requestKey]int64),
+		durations: make(map[string]*durationSummary),
+		updates:   make(map[string]int64),
+	}
+}
+
+func (m *serverMetrics) start() {
+	m.mu.Lock()
+	m.inFlight++
+	m.mu.Unlock()
+}
+
+func (m *serverMetrics) request(handler string, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight--
+	m.requests[requestKey{handler, code}]++
+	s := m.durations[handler]
+	if s == nil {
+		s = &durationSummary{}
+		m.durations[handler] = s
+	}
+	s.sum += d.Seconds()
+	s.count++
+}
+
+// update counts an update made through the API by its journal outcome.
+func (m *serverMetrics) update(outcome string) {
+	m.mu.Lock()
+	m.updates[outcome]++
+	m.mu.Unlock()
+}
+
+func (m *serverMetrics) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "# HELP helm_update_config_http_requests_total Requests to the API by handler and status code.\n")
+	fmt.Fprintf(&b, "# TYPE helm_update_config_http_requests_total counter\n")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].handler != keys[j].handler {
+			return keys[i].handler < keys[j].handler
+		}
+		return keys[i].code < keys[j].code
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "helm_update_config_http_requests_total{handler=%q,code=%q} %d\n", k.handler, strconv.Itoa(k.code), m.requests[k])
+	}
+
+	fmt.Fprintf(&b, "# HELP helm_update_config_http_request_duration_seconds Time spent answering requests to the API.\n")
+	fmt.Fprintf(&b, "# TYPE helm_update_config_http_request_duration_seconds summary\n")
+	handlers := make([]string, 0, len(m.durations))
+	for h := range m.durations {
+		handlers = append(handlers, h)
+	}
+	sort.Strings(handlers)
+	for _, h := range handlers {
+		s := m.durations[h]
+		fmt.Fprintf(&b, "helm_update_config_http_request_duration_seconds_sum{handler=%q} %s\n", h, strconv.FormatFloat(s.sum, 'f', -1, 64))
+		fmt.Fprintf(&b, "helm_update_config_http_request_duration_seconds_count{handler=%q} %d\n", h, s.count)
+	}
+
+	fmt.Fprintf(&b, "# HELP helm_update_config_http_requests_in_flight Requests to the API being answered.\n")
+	fmt.Fprintf(&b, "# TYPE helm_update_config_http_requests_in_flight gauge\n")
+	fmt.Fprintf(&b, "helm_update_config_http_requests_in_flight %d\n", m.inFlight)
+
+	fmt.Fprintf(&b, "# HELP helm_update_config_updates_total Updates applied through the API by outcome.\n")
+	fmt.Fprintf(&b, "# TYPE helm_update_config_updates_total counter\n")
+	outcomes := make([]string, 0, len(m.updates))
+	for o := range m.updates {
+		outcomes = append(outcomes, o)
+	}
+	sort.Strings(outcomes)
+	for _, o := range outcomes {
+		fmt.Fprintf(&b, "helm_update_config_updates_total{outcome=%q} %d\n", o, m.updates[o])
+	}
+
+	_, err := b.WriteTo(w)
+	return err
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+)
+
+// notifyConfig configures the webhooks notified of every update attempt.
+type notifyConfig struct {
+	Webhooks []webhookConfig `json:"webhooks"`
+}
+
+// webhookConfig is a webhook receiving a POST after every update attempt
+// matching its filters. Empty filters match everything.
+type webhookConfig struct {
+	// Name identifies the webhook in warnings. It defaults to the host of
+	// the URL, since the URLs of chat webhooks are secrets.
+	Name string `json:"name"`
+	// URL is the webhook, or URLEnv the environment variable holding it.
+	URL    string `json:"url"`
+	URLEnv string `json:"urlEnv"`
+	// Format is json for the journal record, or slack, discord or teams for
+	// a chat message. Template replaces it with a custom body.
+	Format   string            `json:"format"`
+	Template string            `json:"template"`
+	Headers  map[string]string `json:"headers"`
+
+	Releases   []string `json:"releases"`
+	Namespaces []string `json:"namespaces"`
+	Outcomes   []string `json:"outcomes"`
+
+	// Timeout is the time in seconds for a single attempt, 5 by default.
+	Timeout int64 `json:"timeout"`
+	// Retries is the number of attempts after a failed one, 3 by default.
+	Retries *int `json:"retries"`
+}
+
+const (
+	notifyFormatJSON    = "json"
+	notifyFormatSlack   = "slack"
+	notifyFormatDiscord = "discord"
+	notifyFormatTeams   = "teams"
+)
+
+// notifyTemplates are the bodies of the built-in formats.
+var notifyTemplates = map[string]string{
+	notifyFormatJSON:    `{{json .}}`,
+	notifyFormatSlack:   `{"text": {{summary . | json}}}`,
+	notifyFormatDiscord: `{"content": {{summary . | json}}}`,
+	notifyFormatTeams:   `{"text": {{summary . | json}}}`,
+}
+
+// Global flags adding webhooks to the config file.
+var (
+	notifyURLs   []string
+	notifyFormat string
+)
+
+// notifications tracks deliveries in progress, which main waits for before
+// the process exits.
+var notifications sync.WaitGroup
+
+// notifyDeadline is the longest main waits for deliveries in progress, so
+// that an unreachable webhook does not hold up the exit for every retry.
+const notifyDeadline = 30 * time.Second
+
+// waitNotifications waits for the deliveries in progress for at most timeout
+// and reports whether all of them are done.
+func waitNotifications(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		notifications.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// notifyBackoff is the time to wait before the first retry, doubled with
+// every following one.
+var notifyBackoff = time.Second
+
+type webhook struct {
+	name    string
+	url     string
+	tmpl    *template.Template
+	headers map[string]string
+	filter  webhookConfig
+	retries int
+	client  *http.Client
+}
+
+// notifier delivers the journal records of updates to webhooks.
+type notifier struct {
+	hooks []*webhook
+}
+
+// notifier returns the configured webhooks, or nil if there are none.
+func (c *config) notifier() (*notifier, error) {
+	if len(c.Notify.Webhooks) == 0 {
+		return nil, nil
+	}
+
+	n := &notifier{}
+	for i, wc := range c.Notify.Webhooks {
+		h, err := newWebhook(wc)
+		if err != nil {
+			return nil, fmt.Errorf("notify.webhooks[%d]: %s", i, err)
+		}
+		n.hooks = append(n.hooks, h)
+	}
+
+	return n, nil
+}
+
+func newWebhook(c webhookConfig) (*webhook, error) {
+	h := &webhook{name: c.Name, url: c.URL, headers: c.Headers, filter: c, retries: 3}
+
+	if c.URLEnv != "" {
+		h.url = os.Getenv(c.URLEnv)
+	}
+	u, err := url.Parse(h.url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		// The URL is not part of the error, since it may be a secret.
+		if c.URLEnv != "" {
+			return nil, fmt.Errorf("%s does not hold an http or https url", c.URLEnv)
+		}
+		return nil, fmt.Errorf("url is not an http or https url")
+	}
+	if h.name == "" {
+		h.name = u.Host
+	}
+
+	text := c.Template
+	if text == "" {
+		format := c.Format
+		if format == "" {
+			format = notifyFormatJSON
+		}
+		var ok bool
+		if text, ok = notifyTemplates[format]; !ok {
+			return nil, fmt.Errorf("unknown format %q: use %s, %s, %s or %s", format, notifyFormatJSON, notifyFormatSlack, notifyFormatDiscord, notifyFormatTeams)
+		}
+	}
+	if h.tmpl, err = template.New(h.name).Funcs(notifyFuncs).Parse(text); err != nil {
+		return nil, err
+	}
+
+	for _, p := range append(append(append([]string{}, c.Releases...), c.Namespaces...), c.Outcomes...) {
+		if _, err := globMatch(p, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Retries != nil {
+		if *c.Retries < 0 {
+			return nil, fmt.Errorf("retries must not be negative")
+		}
+		h.retries = *c.Retries
+	}
+	timeout := 5 * time.Second
+	if c.Timeout > 0 {
+		timeout = time.Duration(c.Timeout) * time.Second
+	}
+	h.client = &http.Client{Timeout: timeout}
+
+	return h, nil
+}
+
+var notifyFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"summary": notifySummary,
+}
+
+// matches reports whether the webhook wants to be notified of entry.
+func (h *webhook) matches(entry journalEntry) bool {
+	return matchAny(h.filter.Releases, entry.Release) &&
+		matchAny(h.filter.Namespaces, entry.Namespace) &&
+		matchAny(h.filter.Outcomes, entry.Outcome)
+}
+
+// matchAny reports whether s matches one of patterns, or patterns is empty.
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := globMatch(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// notify delivers entry to the matching webhooks in the background. Failed
+// deliveries are reported on stderr and never fail the update.
+func (n *notifier) notify(entry journalEntry) {
+	for _, h := range n.hooks {
+		if !h.matches(entry) {
+			continue
+		}
+
+		var body bytes.Buffer
+		if err := h.tmpl.Execute(&body, entry); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: could not notify %s: %s\n", h.name, err)
+			continue
+		}
+
+		notifications.Add(1)
+		go func(h *webhook, body []byte) {
+			defer notifications.Done()
+			if err := h.deliver(body); err != nil {
+				fmt.Fprintf(os.Stderr, "WARNING: could not notify %s: %s\n", h.name, err)
+			}
+		}(h, body.Bytes())
+	}
+}
+
+// deliver posts body, retrying after network errors, rate limits and server
+// errors.
+func (h *webhook) deliver(body []byte) error {
+	backoff := notifyBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := h.post(body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == h.retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends body once and reports whether a failure is worth retrying.
+func (h *webhook) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		// The error holds the URL, which is a secret of chat webhooks.
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return true, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+	retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	return retry, fmt.Errorf("webhook responded with %s", res.Status)
+}
+
+// notifySummary describes an update attempt as a short chat message.
+func notifySummary(e journalEntry) string {
+	var b bytes.Buffer
+
+	release := e.Release
+	if e.Namespace != "" {
+		release = fmt.Sprintf("%s (%s)", e.Release, e.Namespace)
+	}
+	by := e.Operator.User
+	if e.Operator.Token != "" {
+		by = fmt.Sprintf("%s (token %s)", by, e.Operator.Token)
+	}
+	if e.Operator.Host != "" {
+		by = fmt.Sprintf("%s on %s", by, e.Operator.Host)
+	}
+	d := time.Duration(e.Duration * float64(time.Second)).Round(100 * time.Millisecond)
+
+	switch e.Outcome {
+	case outcomeSuccess:
+		fmt.Fprintf(&b, "Config of %s updated from revision %d to %d by %s in %s", release, e.BaseRevision, e.NewRevision, by, d)
+	case outcomePreconditionFailed:
+		fmt.Fprintf(&b, "Config update of %s by %s not made: %s", release, by, e.Error)
+	default:
+		fmt.Fprintf(&b, "Config update of %s by %s failed after %s: %s", release, by, d, e.Error)
+	}
+
+	for _, c := range e.Diff {
+		switch {
+		case c.Redacted:
+			fmt.Fprintf(&b, "\n• %s: %s (redacted)", c.Key, c.Kind)
+		case c.Kind == updater.ChangeAdded:
+			fmt.Fprintf(&b, "\n• %s: added %s", c.Key, updater.FormatValue(c.New))
+		case c.Kind == updater.ChangeRemoved:
+			fmt.Fprintf(&b, "\n• %s: removed", c.Key)
+		default:
+			fmt.Fprintf(&b, "\n• %s: %s → %s", c.Key, updater.FormatValue(c.Old), updater.FormatValue(c.New))
+		}
+	}
+
+	return strings.TrimSpace(b.String())
+}
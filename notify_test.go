@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer answers with the given status codes in turn, then with 200,
+// and records the bodies it received.
+type webhookServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	codes  []int
+	bodies []string
+}
+
+func newWebhookServer(codes ...int) *webhookServer {
+	s := &webhookServer{codes: codes}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.bodies = append(s.bodies, string(body))
+		if len(s.codes) > 0 {
+			w.WriteHeader(s.codes[0])
+			s.codes = s.codes[1:]
+		}
+	}))
+	return s
+}
+
+func (s *webhookServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.bodies...)
+}
+
+func TestWebhookRetries(t *testing.T) {
+	backoff := notifyBackoff
+	notifyBackoff = time.Millisecond
+	defer func() { notifyBackoff = backoff }()
+
+	one := 1
+	tests := []struct {
+		codes    []int
+		retries  *int
+		attempts int
+		err      string
+	}{
+		{codes: []int{503, 429}, attempts: 3},
+		{codes: []int{500, 500, 500, 500}, attempts: 4, err: "500 Internal Server Error"},
+		{codes: []int{500, 500}, retries: &one, attempts: 2, err: "500 Internal Server Error"},
+		// The request itself is wrong, so retrying it does not help.
+		{codes: []int{400}, attempts: 1, err: "400 Bad Request"},
+	}
+	for _, tt := range tests {
+		srv := newWebhookServer(tt.codes...)
+		h, err := newWebhook(webhookConfig{URL: srv.URL, Retries: tt.retries})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = h.deliver([]byte(`{}`))
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%v: got error %v, want %q", tt.codes, err, tt.err)
+		}
+		if n := len(srv.requests()); n != tt.attempts {
+			t.Errorf("%v: got %d attempts, want %d", tt.codes, n, tt.attempts)
+		}
+		srv.Close()
+	}
+}
+
+func TestWebhookErrorHidesURL(t *testing.T) {
+	srv := newWebhookServer()
+	srv.Close()
+
+	h, err := newWebhook(webhookConfig{URL: srv.URL + "/hooks/T0SECRET", Retries: new(int)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.deliver([]byte(`{}`)); err == nil || strings.Contains(err.Error(), "T0SECRET") {
+		t.Errorf("got error %v, want one without the url", err)
+	}
+}
+
+func TestNotifyFilters(t *testing.T) {
+	all := newWebhookServer()
+	defer all.Close()
+	prodFailures := newWebhookServer()
+	defer prodFailures.Close()
+	web := newWebhookServer()
+	defer web.Close()
+
+	cfg := &config{Notify: notifyConfig{Webhooks: []webhookConfig{
+		{URL: all.URL},
+		{URL: prodFailures.URL, Namespaces: []string{"prod*"}, Outcomes: []string{outcomeFailure, outcomePreconditionFailed}},
+		{URL: web.URL, Releases: []string{"web-*"}, Format: notifyFormatSlack},
+	}}}
+	n, err := cfg.notifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.notify(journalEntry{Release: "web-1", Namespace: "prod-eu", Outcome: outcomeSuccess, BaseRevision: 1, NewRevision: 2})
+	n.notify(journalEntry{Release: "api", Namespace: "prod-us", Outcome: outcomeFailure, Error: "boom"})
+	n.notify(journalEntry{Release: "api", Namespace: "staging", Outcome: outcomeFailure, Error: "boom"})
+	if !waitNotifications(5 * time.Second) {
+		t.Fatal("notifications were not delivered")
+	}
+
+	if n := len(all.requests()); n != 3 {
+		t.Errorf("webhook without filters got %d notifications, want 3", n)
+	}
+	if got := prodFailures.requests(); len(got) != 1 || !strings.Contains(got[0], `"namespace":"prod-us"`) {
+		t.Errorf("webhook of failures in prod got %q", got)
+	}
+
+	got := web.requests()
+	if len(got) != 1 {
+		t.Fatalf("webhook of web releases got %d notifications, want 1", len(got))
+	}
+	var msg struct{ Text string }
+	if err := json.Unmarshal([]byte(got[0]), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(msg.Text, "Config of web-1 (prod-eu) updated from revision 1 to 2") {
+		t.Errorf("unexpected slack message %q", msg.Text)
+	}
+}
+
+func TestNewWebhookErrors(t *testing.T) {
+	negative := -1
+	tests := []struct {
+		cfg webhookConfig
+		err string
+	}{
+		{webhookConfig{URL: "ftp://example.com"}, "not an http or https url"},
+		{webhookConfig{URLEnv: "HUC_TEST_UNSET_WEBHOOK"}, "HUC_TEST_UNSET_WEBHOOK does not hold"},
+		{webhookConfig{URL: "https://example.com", Format: "irc"}, `unknown format "irc"`},
+		{webhookConfig{URL: "https://example.com", Releases: []string{"[web"}}, ""},
+		{webhookConfig{URL: "https://example.com", Retries: &negative}, "must not be negative"},
+	}
+	for _, tt := range tests {
+		_, err := newWebhook(tt.cfg)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%+v: got error %v, want %q", tt.cfg, err, tt.err)
+		}
+	}
+}
+
+func TestWaitNotificationsDeadline(t *testing.T) {
+	notifications.Add(1)
+	defer notifications.Done()
+
+	if waitNotifications(10 * time.Millisecond) {
+		t.Error("waiting for a delivery in progress did not time out")
+	}
+}
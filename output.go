@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/ghodss/yaml"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+func checkOutputFormat(format string) error {
+	switch format {
+	case outputText, outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q: use %s, %s or %s", format, outputText, outputJSON, outputYAML)
+}
+
+// updateResult is the document printed after an update. Its field names are
+// part of the command line interface and must not change.
+type updateResult struct {
+	Release          string           `json:"release"`
+	Namespace        string           `json:"namespace"`
+	PreviousRevision int32            `json:"previousRevision"`
+	Revision         int32            `json:"revision"`
+	Status           string           `json:"status"`
+	Chart            chartResult      `json:"chart"`
+	DryRun           bool             `json:"dryRun"`
+	ChangedKeys      []string         `json:"changedKeys"`
+	PinnedImages     []pinnedResult   `json:"pinnedImages,omitempty"`
+	Warnings         []string         `json:"warnings,omitempty"`
+	Notes            string           `json:"notes,omitempty"`
+	Resources        []resourceResult `json:"resources,omitempty"`
+}
+
+type resourceResult struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type pinnedResult struct {
+	Path   string `json:"path"`
+	Image  string `json:"image"`
+	Digest string `json:"digest"`
+}
+
+type chartResult struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+func newUpdateResult(res *updater.Result) *updateResult {
+	rel := res.Updated
+
+	out := &updateResult{
+		Release:          res.Release,
+		Namespace:        res.Namespace,
+		PreviousRevision: res.PreviousRevision,
+		Revision:         res.Revision,
+		Status:           res.Status.String(),
+		Chart: chartResult{
+			Name:    rel.GetChart().GetMetadata().GetName(),
+			Version: rel.GetChart().GetMetadata().GetVersion(),
+		},
+		DryRun:      res.DryRun,
+		ChangedKeys: []string{},
+		Warnings:    res.Warnings,
+		Notes:       rel.GetInfo().GetStatus().GetNotes(),
+	}
+
+	for _, c := range res.Changes {
+		out.ChangedKeys = append(out.ChangedKeys, c.Key)
+	}
+	for _, p := range res.Pinned {
+		out.PinnedImages = append(out.PinnedImages, pinnedResult{Path: p.Path, Image: p.Image, Digest: p.Digest})
+	}
+
+	return out
+}
+
+// printWarnings prints the warnings of an update to stderr, where they do not
+// get in the way of the result.
+func printWarnings(warnings []string) {
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", w)
+	}
+}
+
+// statusBackend is implemented by backends that can report the state of the
+// resources of a release.
+type statusBackend interface {
+	Status(name string, version int32) (*release.Status, error)
+}
+
+// addReleaseStatus adds the status of the new revision to out. The update has
+// succeeded at this point, so failing to get the status is only a warning.
+func addReleaseStatus(backend releaseBackend, res *updater.Result, out *updateResult, notes, resources bool) {
+	st := res.Updated.GetInfo().GetStatus()
+
+	if sb, ok := backend.(statusBackend); ok && !res.DryRun && (notes || resources) {
+		s, err := sb.Status(res.Release, res.Revision)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: could not get the status of %s: %s\n", res.Release, err)
+		} else {
+			st = s
+		}
+	}
+
+	out.addStatus(st, notes, resources)
+}
+
+// addStatus replaces the notes of the result and adds the resources of st.
+func (r *updateResult) addStatus(st *release.Status, notes, resources bool) {
+	r.Notes = ""
+	if notes {
+		r.Notes = st.GetNotes()
+	}
+
+	if resources {
+		for _, res := range parseResources(st.GetResources()) {
+			r.Resources = append(r.Resources, resourceResult{Kind: res.Kind, Name: res.Name, Status: res.summary()})
+		}
+	}
+}
+
+func writeUpdateResult(w io.Writer, format string, res *updateResult) error {
+	switch format {
+	case outputJSON:
+		data, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case outputYAML:
+		data, err := yaml.Marshal(res)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	verb := "updated"
+	if res.DryRun {
+		verb = "would be updated"
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Release %s in namespace %s %s: revision %d -> %d\n", res.Release, res.Namespace, verb, res.PreviousRevision, res.Revision)
+	fmt.Fprintf(&b, "Status: %s\n", res.Status)
+	fmt.Fprintf(&b, "Chart: %s-%s\n", res.Chart.Name, res.Chart.Version)
+	if len(res.ChangedKeys) == 0 {
+		fmt.Fprintf(&b, "Changed keys: none\n")
+	} else {
+		fmt.Fprintf(&b, "Changed keys:\n")
+		for _, k := range res.ChangedKeys {
+			fmt.Fprintf(&b, "  %s\n", k)
+		}
+	}
+	if len(res.PinnedImages) > 0 {
+		fmt.Fprintf(&b, "Pinned images:\n")
+		for _, p := range res.PinnedImages {
+			fmt.Fprintf(&b, "  %s: %s@%s\n", p.Path, p.Image, p.Digest)
+		}
+	}
+	if len(res.Resources) > 0 {
+		fmt.Fprintf(&b, "\nResources:\n")
+		w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+		for _, r := range res.Resources {
+			fmt.Fprintf(w, "  %s/%s\t%s\n", r.Kind, r.Name, r.Status)
+		}
+		w.Flush()
+	}
+	if res.Notes != "" {
+		fmt.Fprintf(&b, "\nNotes:\n%s\n", strings.TrimRight(res.Notes, "\n"))
+	}
+
+	_, err := b.WriteTo(w)
+	return err
+}
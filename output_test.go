@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+func TestWriteUpdateResult(t *testing.T) {
+	updated := fakeRelease("web", "prod", 4, "", "")
+	updated.Info.Status.Notes = "Visit https://web.example.com\n"
+	res := newUpdateResult(&updater.Result{
+		Release:          "web",
+		Namespace:        "prod",
+		PreviousRevision: 3,
+		Revision:         4,
+		Status:           release.Status_DEPLOYED,
+		Changes:          []updater.Change{{Key: "image.tag", Kind: "changed"}, {Key: "replicas", Kind: "added"}},
+		Pinned:           []updater.PinnedImage{{Path: "image.tag", Image: "example.com/web:v2", Digest: "sha256:0123"}},
+		Warnings:         []string{"could not check example.com/db:v1"},
+		Updated:          updated,
+	})
+	res.Resources = []resourceResult{{Kind: "Deployment", Name: "web", Status: "2/2 ready"}}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{outputText, `Release web in namespace prod updated: revision 3 -> 4
+Status: DEPLOYED
+Chart: app-1.0.0
+Changed keys:
+  image.tag
+  replicas
+Pinned images:
+  image.tag: example.com/web:v2@sha256:0123
+
+Resources:
+  Deployment/web  2/2 ready
+
+Notes:
+Visit https://web.example.com
+`},
+		{outputJSON, `{
+  "release": "web",
+  "namespace": "prod",
+  "previousRevision": 3,
+  "revision": 4,
+  "status": "DEPLOYED",
+  "chart": {
+    "name": "app",
+    "version": "1.0.0"
+  },
+  "dryRun": false,
+  "changedKeys": [
+    "image.tag",
+    "replicas"
+  ],
+  "pinnedImages": [
+    {
+      "path": "image.tag",
+      "image": "example.com/web:v2",
+      "digest": "sha256:0123"
+    }
+  ],
+  "warnings": [
+    "could not check example.com/db:v1"
+  ],
+  "notes": "Visit https://web.example.com\n",
+  "resources": [
+    {
+      "kind": "Deployment",
+      "name": "web",
+      "status": "2/2 ready"
+    }
+  ]
+}
+`},
+		{outputYAML, `changedKeys:
+- image.tag
+- replicas
+chart:
+  name: app
+  version: 1.0.0
+dryRun: false
+namespace: prod
+notes: |
+  Visit https://web.example.com
+pinnedImages:
+- digest: sha256:0123
+  image: example.com/web:v2
+  path: image.tag
+previousRevision: 3
+release: web
+resources:
+- kind: Deployment
+  name: web
+  status: 2/2 ready
+revision: 4
+status: DEPLOYED
+warnings:
+- could not check example.com/db:v1
+`},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := writeUpdateResult(&b, tt.format, res); err != nil {
+			t.Errorf("%s: %s", tt.format, err)
+			continue
+		}
+		if b.String() != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.format, b.String(), tt.want)
+		}
+	}
+}
+
+func TestWriteUpdateResultDryRun(t *testing.T) {
+	res := newUpdateResult(&updater.Result{
+		Release:          "web",
+		Namespace:        "prod",
+		PreviousRevision: 3,
+		Revision:         4,
+		Status:           release.Status_PENDING_UPGRADE,
+		DryRun:           true,
+		Updated:          fakeRelease("web", "prod", 4, "", ""),
+	})
+
+	var b bytes.Buffer
+	if err := writeUpdateResult(&b, outputText, res); err != nil {
+		t.Fatal(err)
+	}
+	want := `Release web in namespace prod would be updated: revision 3 -> 4
+Status: PENDING_UPGRADE
+Chart: app-1.0.0
+Changed keys: none
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+
+	// Scripts can tell that nothing changed without checking for null.
+	b.Reset()
+	if err := writeUpdateResult(&b, outputJSON, res); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b.Bytes(), []byte(`"changedKeys": []`)) || !bytes.Contains(b.Bytes(), []byte(`"dryRun": true`)) {
+		t.Errorf("unexpected JSON of a dry run without changes:\n%s", b.String())
+	}
+}
@@ -0,0 +1,192 @@
+package updater
+
+import (
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+// Backend is where releases are read from and new revisions are written to.
+// Releases of every backend are represented with the Helm 2 types.
+type Backend interface {
+	// Release returns the latest revision of a release.
+	Release(name string) (*release.Release, error)
+	// ListReleases returns the latest revision of every release.
+	ListReleases() ([]*release.Release, error)
+	// History returns up to max revisions of a release, oldest first.
+	History(name string, max int32) ([]*release.Release, error)
+	// Update writes a new revision of current and returns it.
+	Update(current *release.Release, req Request) (*release.Release, error)
+}
+
+// Request describes a new revision of a release.
+type Request struct {
+	// Values is a YAML document with user-supplied values.
+	Values []byte
+	// ReuseValues merges Values on top of the current config. Otherwise
+	// Values replace the current config.
+	ReuseValues bool
+	// DryRun returns the new revision without writing it.
+	DryRun bool
+	// Wait waits until the resources of the release are ready, for at most
+	// Timeout seconds. Backends that cannot wait ignore it.
+	Wait    bool
+	Timeout int64
+}
+
+// TillerBackend talks to Tiller, as Helm 2 does. Errors are returned as
+// *TillerError.
+type TillerBackend struct {
+	Client helm.Interface
+}
+
+func (b *TillerBackend) Release(name string) (*release.Release, error) {
+	res, err := b.Client.ReleaseContent(name)
+	if err != nil {
+		return nil, &TillerError{Op: "ReleaseContent", Err: err}
+	}
+	return res.Release, nil
+}
+
+func (b *TillerBackend) ListReleases() ([]*release.Release, error) {
+	var (
+		rels   []*release.Release
+		offset string
+	)
+
+	for {
+		res, err := b.Client.ListReleases(
+			helm.ReleaseListOffset(offset),
+			helm.ReleaseListStatuses([]release.Status_Code{release.Status_DEPLOYED, release.Status_FAILED}),
+		)
+		if err != nil {
+			return nil, &TillerError{Op: "ListReleases", Err: err}
+		}
+		rels = append(rels, res.Releases...)
+
+		if res.Next == "" {
+			return rels, nil
+		}
+		offset = res.Next
+	}
+}
+
+func (b *TillerBackend) History(name string, max int32) ([]*release.Release, error) {
+	res, err := b.Client.ReleaseHistory(name, helm.WithMaxHistory(max))
+	if err != nil {
+		return nil, &TillerError{Op: "ReleaseHistory", Err: err}
+	}
+
+	// Tiller returns the newest revision first.
+	rels := res.Releases
+	for i, j := 0, len(rels)-1; i < j; i, j = i+1, j-1 {
+		rels[i], rels[j] = rels[j], rels[i]
+	}
+
+	return rels, nil
+}
+
+func (b *TillerBackend) Update(current *release.Release, req Request) (*release.Release, error) {
+	opts := []helm.UpdateOption{
+		helm.UpdateValueOverrides(req.Values),
+		helm.UpgradeDryRun(req.DryRun),
+		helm.UpgradeWait(req.Wait),
+	}
+	if req.Timeout > 0 {
+		opts = append(opts, helm.UpgradeTimeout(req.Timeout))
+	}
+	if req.ReuseValues {
+		opts = append(opts, helm.ReuseValues(true))
+	} else {
+		opts = append(opts, helm.ResetValues(true))
+	}
+
+	res, err := b.Client.UpdateReleaseFromChart(current.Name, current.Chart, opts...)
+	if err != nil {
+		return nil, &TillerError{Op: "UpdateRelease", Err: err}
+	}
+
+	return res.GetRelease(), nil
+}
+
+// Status returns the status of a revision of a release, including its notes
+// and the state of its resources.
+func (b *TillerBackend) Status(name string, version int32) (*release.Status, error) {
+	res, err := b.Client.ReleaseStatus(name, helm.StatusReleaseVersion(version))
+	if err != nil {
+		return nil, &TillerError{Op: "ReleaseStatus", Err: err}
+	}
+	return res.GetInfo().GetStatus(), nil
+}
+
+// RunTests runs the tests of a release and calls fn with every message
+// Tiller streams back. It returns the number of failed tests.
+func (b *TillerBackend) RunTests(name string, timeout int64, cleanup bool, fn func(*services.TestReleaseResponse)) (int, error) {
+	c, errc := b.Client.RunReleaseTest(name, helm.ReleaseTestTimeout(timeout), helm.ReleaseTestCleanup(cleanup))
+
+	failed := 0
+	report := func(res *services.TestReleaseResponse) {
+		if res.Status == release.TestRun_FAILURE {
+			failed++
+		}
+		fn(res)
+	}
+
+	for {
+		select {
+		case err := <-errc:
+			// The error channel is closed after the message channel, which
+			// may still hold a message.
+			if c != nil {
+				for res := range c {
+					report(res)
+				}
+			}
+			if err != nil {
+				return failed, &TillerError{Op: "RunReleaseTest", Err: err}
+			}
+			return failed, nil
+		case res, ok := <-c:
+			if !ok {
+				c = nil
+				continue
+			}
+			report(res)
+		}
+	}
+}
+
+// Rollback rolls a release back to an earlier revision.
+func (b *TillerBackend) Rollback(name string, version int32, wait bool, timeout int64) error {
+	_, err := b.Client.RollbackRelease(name,
+		helm.RollbackVersion(version),
+		helm.RollbackWait(wait),
+		helm.RollbackTimeout(timeout),
+	)
+	if err != nil {
+		return &TillerError{Op: "RollbackRelease", Err: err}
+	}
+	return nil
+}
+
+// Install installs a new release of ch with a YAML document of user-supplied
+// values and returns it.
+func (b *TillerBackend) Install(ch *chart.Chart, namespace, name string, req Request) (*release.Release, error) {
+	opts := []helm.InstallOption{
+		helm.ReleaseName(name),
+		helm.ValueOverrides(req.Values),
+		helm.InstallDryRun(req.DryRun),
+		helm.InstallWait(req.Wait),
+	}
+	if req.Timeout > 0 {
+		opts = append(opts, helm.InstallTimeout(req.Timeout))
+	}
+
+	res, err := b.Client.InstallReleaseFromChart(ch, namespace, opts...)
+	if err != nil {
+		return nil, &TillerError{Op: "InstallRelease", Err: err}
+	}
+
+	return res.GetRelease(), nil
+}
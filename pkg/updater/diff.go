@@ -0,0 +1,246 @@
+package updater
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Change describes a single changed key between two sets of values.
+type Change struct {
+	Key  string      `json:"key"`
+	Kind string      `json:"kind"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+	// Redacted is set when Old and New hold fingerprints instead of values.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// DiffValues compares two values trees key by key. Tables are descended into,
+// everything else, including lists, is compared as a whole.
+func DiffValues(oldVals, newVals map[string]interface{}) []Change {
+	o := make(map[string]interface{})
+	n := make(map[string]interface{})
+	flattenValues("", oldVals, o)
+	flattenValues("", newVals, n)
+
+	var changes []Change
+	for k, ov := range o {
+		nv, ok := n[k]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Key: k, Kind: ChangeRemoved, Old: ov})
+		case FormatValue(ov) != FormatValue(nv):
+			changes = append(changes, Change{Key: k, Kind: ChangeChanged, Old: ov, New: nv})
+		}
+	}
+	for k, nv := range n {
+		if _, ok := o[k]; !ok {
+			changes = append(changes, Change{Key: k, Kind: ChangeAdded, New: nv})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+func flattenValues(prefix string, v interface{}, out map[string]interface{}) {
+	m, ok := v.(map[string]interface{})
+	if !ok || (len(m) == 0 && prefix != "") {
+		out[prefix] = v
+		return
+	}
+
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		flattenValues(k, v, out)
+	}
+}
+
+// FormatChanges renders changes one key per line.
+func FormatChanges(changes []Change) string {
+	var b bytes.Buffer
+	for _, c := range changes {
+		if c.Redacted {
+			switch c.Kind {
+			case ChangeAdded:
+				fmt.Fprintf(&b, "+ %s: <redacted> (%s)\n", c.Key, c.New)
+			case ChangeRemoved:
+				fmt.Fprintf(&b, "- %s: <redacted> (%s)\n", c.Key, c.Old)
+			default:
+				fmt.Fprintf(&b, "~ %s: <changed, redacted> (%s -> %s)\n", c.Key, c.Old, c.New)
+			}
+			continue
+		}
+
+		switch c.Kind {
+		case ChangeAdded:
+			fmt.Fprintf(&b, "+ %s: %s\n", c.Key, FormatValue(c.New))
+		case ChangeRemoved:
+			fmt.Fprintf(&b, "- %s: %s\n", c.Key, FormatValue(c.Old))
+		default:
+			fmt.Fprintf(&b, "~ %s: %s -> %s\n", c.Key, FormatValue(c.Old), FormatValue(c.New))
+		}
+	}
+
+	return b.String()
+}
+
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines computes a shortest edit script between a and b using the Myers algorithm.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	v := make([]int, 2*max+2)
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[max+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', b[y-1]})
+			} else {
+				ops = append(ops, diffOp{'-', a[x-1]})
+			}
+			x, y = prevX, prevY
+		}
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// UnifiedDiff renders the difference between two texts in unified diff format.
+// It returns an empty string if they are equal.
+func UnifiedDiff(fromName, toName, from, to string) string {
+	if from == to {
+		return ""
+	}
+
+	const context = 3
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// Extend the hunk until there are more than 2*context unchanged lines in a row.
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		stop := end + context
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		aStart, bStart := 1, 1
+		for _, op := range ops[:start] {
+			if op.kind != '+' {
+				aStart++
+			}
+			if op.kind != '-' {
+				bStart++
+			}
+		}
+		var aLen, bLen int
+		for _, op := range ops[start:stop] {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+
+		// An empty range starts at the line before it, like GNU diff does.
+		if aLen == 0 {
+			aStart--
+		}
+		if bLen == 0 {
+			bStart--
+		}
+
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+		for _, op := range ops[start:stop] {
+			fmt.Fprintf(&b, "%c%s\n", op.kind, op.line)
+		}
+
+		i = stop
+	}
+
+	return b.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
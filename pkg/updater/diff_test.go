@@ -0,0 +1,47 @@
+package updater
+
+import "testing"
+
+func TestDiffValues(t *testing.T) {
+	oldVals := mustDecode(t, `{"a": 1, "b": {"c": "x", "d": [1, 2]}, "e": {}, "f": true}`).(map[string]interface{})
+	newVals := mustDecode(t, `{"a": 1, "b": {"c": "y", "d": [1, 3]}, "e": {"g": 1}, "h": null}`).(map[string]interface{})
+
+	// Lists are compared as a whole, empty tables are values of their own.
+	got := FormatChanges(DiffValues(oldVals, newVals))
+	want := "~ b.c: x -> y\n~ b.d: [1,2] -> [1,3]\n- e: {}\n+ e.g: 1\n- f: true\n+ h: null\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatChanges(t *testing.T) {
+	changes := []Change{
+		{Key: "a", Kind: ChangeAdded, New: "x"},
+		{Key: "b", Kind: ChangeRemoved, Old: []interface{}{1.0}},
+		{Key: "c", Kind: ChangeChanged, Old: 1.0, New: 2.0},
+		{Key: "d", Kind: ChangeChanged, Old: "hmac:1", New: "hmac:2", Redacted: true},
+	}
+
+	got := FormatChanges(changes)
+	want := "+ a: x\n- b: [1]\n~ c: 1 -> 2\n~ d: <changed, redacted> (hmac:1 -> hmac:2)\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	if got := UnifiedDiff("a", "b", "x\n", "x\n"); got != "" {
+		t.Errorf("got %q for equal texts", got)
+	}
+
+	got := UnifiedDiff("a", "b", "1\n2\n3\n", "1\n3\n4\n")
+	want := "--- a\n+++ b\n@@ -1,3 +1,3 @@\n 1\n-2\n 3\n+4\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got = UnifiedDiff("a", "b", "", "1\n")
+	if want := "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+package updater
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// ImagePath locates an image in the values of a release. Either Image is the
+// path of a complete reference like "nginx:1.13", or Repository and Tag are
+// the paths of its parts, with an optional Registry prepended to Repository.
+type ImagePath struct {
+	Image      string `json:"image,omitempty"`
+	Registry   string `json:"registry,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+}
+
+// ParseImagePath parses the path of a complete image reference, like
+// "app.image", or a list of parts, like "repository=app.repo,tag=app.version".
+func ParseImagePath(s string) (ImagePath, error) {
+	if !strings.Contains(s, "=") {
+		return ImagePath{Image: s}, nil
+	}
+
+	var p ImagePath
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return p, fmt.Errorf("invalid image path %q: expected a path or repository=path,tag=path", s)
+		}
+		switch kv[0] {
+		case "image":
+			p.Image = kv[1]
+		case "registry":
+			p.Registry = kv[1]
+		case "repository":
+			p.Repository = kv[1]
+		case "tag":
+			p.Tag = kv[1]
+		default:
+			return p, fmt.Errorf("invalid image path %q: unknown part %s", s, kv[0])
+		}
+	}
+
+	if p.Image == "" && (p.Repository == "" || p.Tag == "") {
+		return p, fmt.Errorf("invalid image path %q: repository and tag are required", s)
+	}
+	return p, nil
+}
+
+// PinnedImage is an image whose tag has been pinned to a digest.
+type PinnedImage struct {
+	// Path is the key the digest has been written to.
+	Path   string
+	Image  string
+	Digest string
+}
+
+// findImages returns the images at paths in vals. Without paths, every table
+// named image with a repository and a tag is one, like Helm charts usually
+// lay them out.
+func findImages(vals map[string]interface{}, paths []ImagePath) []ImagePath {
+	if len(paths) > 0 {
+		return paths
+	}
+
+	var found []ImagePath
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			sub, ok := m[k].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			path := prefix + k
+			if k == "image" {
+				_, hasRepo := sub["repository"]
+				_, hasTag := sub["tag"]
+				if hasRepo && hasTag {
+					p := ImagePath{Repository: path + ".repository", Tag: path + ".tag"}
+					if _, ok := sub["registry"]; ok {
+						p.Registry = path + ".registry"
+					}
+					found = append(found, p)
+					continue
+				}
+			}
+			walk(path+".", sub)
+		}
+	}
+	walk("", vals)
+
+	return found
+}
+
+// pinDigests resolves the tags of the images in the new config of rel and
+// writes tag@digest back into the values. Images which are already pinned or
+// have no tag are left alone.
+func (u *update) pinDigests(rel *release.Release, config []byte) ([]PinnedImage, error) {
+	vals, err := chartutil.CoalesceValues(rel.Chart, &chart.Config{Raw: string(config)})
+	if err != nil {
+		return nil, err
+	}
+
+	var pinned []PinnedImage
+	for _, p := range findImages(vals, u.opts.ImagePaths) {
+		var (
+			path  string
+			image string
+			tag   string
+		)
+
+		if p.Image != "" {
+			v, ok := LookupValue(vals, p.Image)
+			if !ok {
+				continue
+			}
+			path, image = p.Image, FormatValue(v)
+			if strings.Contains(image, "@") {
+				continue
+			}
+		} else {
+			repo, ok := LookupValue(vals, p.Repository)
+			if !ok {
+				continue
+			}
+			t, ok := LookupValue(vals, p.Tag)
+			if !ok || FormatValue(t) == "" {
+				continue
+			}
+			path, tag = p.Tag, FormatValue(t)
+			if strings.Contains(tag, "@") {
+				continue
+			}
+			image = FormatValue(repo) + ":" + tag
+			if p.Registry != "" {
+				if reg, ok := LookupValue(vals, p.Registry); ok && FormatValue(reg) != "" {
+					image = FormatValue(reg) + "/" + image
+				}
+			}
+		}
+
+		digest, err := u.registry.Digest(image)
+		if err != nil {
+			return nil, fmt.Errorf("pinning %s: %s", path, err)
+		}
+
+		if p.Image != "" {
+			SetValue(u.values, path, image+"@"+digest)
+		} else {
+			SetValue(u.values, path, tag+"@"+digest)
+		}
+		pinned = append(pinned, PinnedImage{Path: path, Image: image, Digest: digest})
+	}
+
+	return pinned, nil
+}
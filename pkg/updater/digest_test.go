@@ -0,0 +1,101 @@
+package updater
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+func TestParseImagePath(t *testing.T) {
+	tests := map[string]ImagePath{
+		"app.image":                           {Image: "app.image"},
+		"repository=app.repo,tag=app.version": {Repository: "app.repo", Tag: "app.version"},
+		"registry=global.registry,repository=app.repo,tag=t": {Registry: "global.registry", Repository: "app.repo", Tag: "t"},
+	}
+	for s, want := range tests {
+		got, err := ParseImagePath(s)
+		if err != nil {
+			t.Errorf("ParseImagePath(%q): %s", s, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ParseImagePath(%q) = %+v, want %+v", s, got, want)
+		}
+	}
+
+	for _, s := range []string{"repository=app.repo", "tag=", "digest=app.digest,tag=t"} {
+		if _, err := ParseImagePath(s); err == nil {
+			t.Errorf("ParseImagePath(%q) was accepted", s)
+		}
+	}
+}
+
+func TestFindImages(t *testing.T) {
+	vals := mustDecode(t, `{
+		"image": {"repository": "nginx", "tag": "1.25"},
+		"sidecar": {"image": {"registry": "ghcr.io", "repository": "org/proxy", "tag": "v2"}},
+		"other": {"image": {"repository": "no-tag"}}
+	}`).(map[string]interface{})
+
+	got := findImages(vals, nil)
+	want := []ImagePath{
+		{Repository: "image.repository", Tag: "image.tag"},
+		{Registry: "sidecar.image.registry", Repository: "sidecar.image.repository", Tag: "sidecar.image.tag"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	paths := []ImagePath{{Image: "app"}}
+	if got := findImages(vals, paths); !reflect.DeepEqual(got, paths) {
+		t.Errorf("got %+v, want the given paths", got)
+	}
+}
+
+func TestPinDigests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasSuffix(req.URL.Path, "/manifests/v1") {
+			http.NotFound(w, req)
+			return
+		}
+		serveManifest(w, req)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	rel := &release.Release{Chart: &chart.Chart{Values: &chart.Config{Raw: `
+image:
+  registry: ` + host + `
+  repository: app
+  tag: v1
+sidecar:
+  image:
+    repository: ` + host + `/proxy
+    tag: v1@sha256:abc
+`}}}
+
+	u := &update{values: map[string]interface{}{}, registry: newTestRegistry(t, `{}`)}
+	pinned, err := u.pinDigests(rel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []PinnedImage{{Path: "image.tag", Image: host + "/app:v1", Digest: testIndexDigest}}
+	if !reflect.DeepEqual(pinned, want) {
+		t.Errorf("got %+v, want %+v", pinned, want)
+	}
+	if got := FormatValue(u.values); got != `{"image":{"tag":"v1@`+testIndexDigest+`"}}` {
+		t.Errorf("unexpected values %s", got)
+	}
+
+	u = &update{values: map[string]interface{}{}, registry: newTestRegistry(t, `{}`)}
+	_, err = u.pinDigests(rel, []byte("image:\n  tag: v2\n"))
+	if err == nil || !strings.Contains(err.Error(), "pinning image.tag") || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("got error %v for a missing tag", err)
+	}
+}
@@ -0,0 +1,54 @@
+package updater
+
+import "fmt"
+
+// ConflictError is returned when a release is not in the state an update
+// expects: a precondition does not hold, a JSON Patch test operation fails or
+// the release changed since the update was prepared.
+type ConflictError struct {
+	Release string
+	Err     error
+}
+
+func (e *ConflictError) Error() string {
+	return e.Err.Error()
+}
+
+// ValidationError is returned when the options of an update are invalid, for
+// example when a value cannot be parsed or a patch cannot be applied.
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+// RevisionError is the cause of a *ConflictError when a release is not at
+// the revision given by Options.IfRevision.
+type RevisionError struct {
+	Release  string
+	Current  int32
+	Expected int32
+}
+
+func (e *RevisionError) Error() string {
+	return fmt.Sprintf("release %s is at revision %d, expected revision %d", e.Release, e.Current, e.Expected)
+}
+
+// TillerError is returned when a request to Tiller fails.
+type TillerError struct {
+	// Op is the Tiller call that failed.
+	Op  string
+	Err error
+}
+
+func (e *TillerError) Error() string {
+	return e.Err.Error()
+}
+
+// IsConflict reports whether err is a *ConflictError.
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
@@ -0,0 +1,61 @@
+package updater
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MissingImagesError is returned when images referenced by the new manifest
+// do not exist in their registry.
+type MissingImagesError struct {
+	Images []string
+}
+
+func (e *MissingImagesError) Error() string {
+	return "images not found in the registry: " + strings.Join(e.Images, ", ")
+}
+
+var manifestImageRe = regexp.MustCompile(`(?m)^\s*(?:-\s+)?image:\s*["']?([^"'\s#]+)["']?\s*(?:#.*)?$`)
+
+// ManifestImages returns the image references of the containers in a
+// rendered manifest, sorted and without duplicates.
+func ManifestImages(manifest string) []string {
+	seen := make(map[string]bool)
+	var images []string
+	for _, m := range manifestImageRe.FindAllStringSubmatch(manifest, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			images = append(images, m[1])
+		}
+	}
+	sort.Strings(images)
+	return images
+}
+
+// CheckImages returns a *MissingImagesError if any image of manifest is not
+// in its registry. Images which cannot be looked up, because the registry
+// refuses the credentials or cannot be reached, may well exist: they are
+// returned as warnings instead.
+func CheckImages(r *Registry, manifest string) ([]string, error) {
+	var (
+		missing  []string
+		warnings []string
+	)
+	for _, image := range ManifestImages(manifest) {
+		ok, err := r.Exists(image)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("could not check image %s: %s", image, err))
+			continue
+		}
+		if !ok {
+			missing = append(missing, image)
+		}
+	}
+
+	if len(missing) > 0 {
+		return warnings, &MissingImagesError{Images: missing}
+	}
+	return warnings, nil
+}
@@ -0,0 +1,63 @@
+package updater
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestManifestImages(t *testing.T) {
+	manifest := `kind: Deployment
+spec:
+  template:
+    spec:
+      initContainers:
+      - image: busybox:1.36
+      containers:
+      - name: app
+        image: "registry.example.com/app:v1" # pinned by CI
+      - name: proxy
+        image: 'envoy:v1.28'
+      - image: busybox:1.36
+`
+	want := []string{"busybox:1.36", "envoy:v1.28", "registry.example.com/app:v1"}
+	if got := ManifestImages(manifest); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCheckImages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/app/manifests/v1":
+			serveManifest(w, req)
+		case "/v2/private/manifests/v1":
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	r := newTestRegistry(t, `{}`)
+	manifest := "- image: " + host + "/app:v1\n- image: " + host + "/app:v2\n- image: " + host + "/private:v1\n"
+
+	// The private image cannot be looked up, which is no reason to believe
+	// it is missing.
+	warnings, err := CheckImages(r, manifest)
+	missing, ok := err.(*MissingImagesError)
+	if !ok || !reflect.DeepEqual(missing.Images, []string{host + "/app:v2"}) {
+		t.Errorf("got error %v, want %s/app:v2 to be missing", err, host)
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "could not check image "+host+"/private:v1: ") {
+		t.Errorf("got warnings %q", warnings)
+	}
+
+	if warnings, err := CheckImages(r, "image: "+host+"/app:v1\n"); err != nil || len(warnings) > 0 {
+		t.Errorf("got %q, %v for an existing image", warnings, err)
+	}
+}
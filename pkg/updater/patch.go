@@ -0,0 +1,477 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// Patch transforms a release config document decoded from JSON.
+type Patch interface {
+	apply(doc interface{}) (interface{}, error)
+}
+
+// jsonPatch is an RFC 6902 JSON Patch.
+type jsonPatch []jsonPatchOp
+
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// mergePatch is an RFC 7386 JSON Merge Patch.
+type mergePatch struct {
+	patch interface{}
+}
+
+// TestFailedError is returned when a JSON Patch "test" operation does not match.
+type TestFailedError struct {
+	Path string
+}
+
+func (e *TestFailedError) Error() string {
+	return fmt.Sprintf("patch test failed: value at %q does not match", e.Path)
+}
+
+// ReadJSONPatchFile reads a JSON Patch document. YAML is accepted as well.
+func ReadJSONPatchFile(filename string) (Patch, error) {
+	data, err := readJSONFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var p jsonPatch
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	return p, nil
+}
+
+// ReadMergePatchFile reads a JSON Merge Patch document. YAML is accepted as well.
+func ReadMergePatchFile(filename string) (Patch, error) {
+	data, err := readJSONFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := DecodeJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	return &mergePatch{patch: doc}, nil
+}
+
+func readJSONFile(filename string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	return data, nil
+}
+
+// DecodeJSON decodes a JSON document, keeping numbers as json.Number so that
+// large integers survive a round trip.
+func DecodeJSON(data []byte) (interface{}, error) {
+	var doc interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+func (p jsonPatch) apply(doc interface{}) (interface{}, error) {
+	var err error
+	for i, op := range p {
+		doc, err = op.apply(doc)
+		if err != nil {
+			if _, ok := err.(*TestFailedError); ok {
+				return nil, err
+			}
+			return nil, fmt.Errorf("patch operation %d (%s %s): %s", i, op.Op, op.Path, err)
+		}
+	}
+
+	return doc, nil
+}
+
+func (op jsonPatchOp) value() (interface{}, error) {
+	if op.Value == nil {
+		return nil, fmt.Errorf("missing value")
+	}
+
+	return DecodeJSON(op.Value)
+}
+
+func (op jsonPatchOp) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+
+	case "remove":
+		doc, _, err := pointerRemove(doc, path)
+		return doc, err
+
+	case "replace":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return v, nil
+		}
+		if doc, _, err = pointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" && len(from) < len(path) && strings.HasPrefix(op.Path+"/", op.From+"/") {
+			return nil, fmt.Errorf("cannot move %q into one of its children", op.From)
+		}
+		v, err := pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if doc, _, err = pointerRemove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			v = DeepCopy(v)
+		}
+		return pointerAdd(doc, path, v)
+
+	case "test":
+		want, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := pointerGet(doc, path)
+		if err != nil || !jsonEqual(got, want) {
+			return nil, &TestFailedError{Path: op.Path}
+		}
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("unknown operation %q", op.Op)
+}
+
+func (p *mergePatch) apply(doc interface{}) (interface{}, error) {
+	return ApplyMergePatch(doc, p.patch), nil
+}
+
+// ApplyMergePatch applies an RFC 7386 merge patch to target, which is modified
+// in place if it is a map. Values of the patch are copied, so later changes of
+// target do not change the patch.
+func ApplyMergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return DeepCopy(patch)
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = ApplyMergePatch(t[k], v)
+		}
+	}
+
+	return t
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped reference tokens.
+func parsePointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if ptr[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer %q", ptr)
+	}
+
+	tokens := strings.Split(ptr[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d out of bounds", i)
+	}
+
+	return i, nil
+}
+
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, t := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			v, ok := node[t]
+			if !ok {
+				return nil, fmt.Errorf("key %q not found", t)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("cannot reference %q in a scalar value", t)
+		}
+	}
+
+	return doc, nil
+}
+
+// pointerUpdate descends to the parent of the last path token and replaces
+// the parent with the result of fn.
+func pointerUpdate(doc interface{}, path []string, fn func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	t := path[0]
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[t]
+		if !ok {
+			return nil, fmt.Errorf("key %q not found", t)
+		}
+		child, err := pointerUpdate(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[t] = child
+		return node, nil
+	case []interface{}:
+		i, err := arrayIndex(t, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := pointerUpdate(node[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("cannot reference %q in a scalar value", t)
+}
+
+func pointerAdd(doc interface{}, path []string, v interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+
+	return pointerUpdate(doc, path, func(parent interface{}, t string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[t] = v
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(t, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = v
+			return node, nil
+		}
+		return nil, fmt.Errorf("cannot add %q to a scalar value", t)
+	})
+}
+
+func pointerRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+
+	var removed interface{}
+	doc, err := pointerUpdate(doc, path, func(parent interface{}, t string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			v, ok := node[t]
+			if !ok {
+				return nil, fmt.Errorf("key %q not found", t)
+			}
+			removed = v
+			delete(node, t)
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(t, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = node[i]
+			return append(node[:i], node[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("cannot remove %q from a scalar value", t)
+	})
+
+	return doc, removed, err
+}
+
+// DeepCopy copies the maps and lists of a decoded JSON or YAML document.
+func DeepCopy(v interface{}) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(node))
+		for k, v := range node {
+			m[k] = DeepCopy(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(node))
+		for i, v := range node {
+			s[i] = DeepCopy(v)
+		}
+		return s
+	}
+
+	return v
+}
+
+// jsonEqual compares two decoded JSON values, treating numbers by value.
+func jsonEqual(a, b interface{}) bool {
+	an, aok := a.(json.Number)
+	bn, bok := b.(json.Number)
+	if aok && bok {
+		af, aerr := an.Float64()
+		bf, berr := bn.Float64()
+		if aerr == nil && berr == nil {
+			return af == bf
+		}
+		return an == bn
+	}
+
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			w, ok := bv[k]
+			if !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// patchConfig applies patches to a YAML config document and returns the result as YAML.
+func patchConfig(raw string, patches []Patch, overrides map[string]interface{}) ([]byte, error) {
+	data, err := yaml.YAMLToJSON([]byte(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := DecodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+
+	for _, p := range patches {
+		if doc, err = p.apply(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(overrides) > 0 {
+		data, err := json.Marshal(overrides)
+		if err != nil {
+			return nil, err
+		}
+		o, err := DecodeJSON(data)
+		if err != nil {
+			return nil, err
+		}
+		doc = ApplyMergePatch(doc, o)
+	}
+
+	if _, ok := doc.(map[string]interface{}); !ok && doc != nil {
+		return nil, fmt.Errorf("patched config must be a map, got %T", doc)
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.JSONToYAML(out)
+}
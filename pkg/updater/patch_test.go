@@ -0,0 +1,212 @@
+package updater
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustDecode(t *testing.T, s string) interface{} {
+	t.Helper()
+	v, err := DecodeJSON([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func mustJSONPatch(t *testing.T, s string) Patch {
+	t.Helper()
+	var p jsonPatch
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+		err   string
+	}{
+		{
+			name:  "add to map",
+			doc:   `{"a": 1}`,
+			patch: `[{"op": "add", "path": "/b", "value": {"c": 2}}]`,
+			want:  `{"a": 1, "b": {"c": 2}}`,
+		},
+		{
+			name:  "add to end of list",
+			doc:   `{"l": [1, 2]}`,
+			patch: `[{"op": "add", "path": "/l/-", "value": 3}]`,
+			want:  `{"l": [1, 2, 3]}`,
+		},
+		{
+			name:  "insert into list",
+			doc:   `{"l": [1, 3]}`,
+			patch: `[{"op": "add", "path": "/l/1", "value": 2}]`,
+			want:  `{"l": [1, 2, 3]}`,
+		},
+		{
+			name:  "remove",
+			doc:   `{"a": 1, "l": [1, 2, 3]}`,
+			patch: `[{"op": "remove", "path": "/a"}, {"op": "remove", "path": "/l/0"}]`,
+			want:  `{"l": [2, 3]}`,
+		},
+		{
+			name:  "replace",
+			doc:   `{"a": {"b": 1}}`,
+			patch: `[{"op": "replace", "path": "/a/b", "value": "x"}]`,
+			want:  `{"a": {"b": "x"}}`,
+		},
+		{
+			name:  "replace whole document",
+			doc:   `{"a": 1}`,
+			patch: `[{"op": "replace", "path": "", "value": {"b": 2}}]`,
+			want:  `{"b": 2}`,
+		},
+		{
+			name:  "move",
+			doc:   `{"a": {"b": 1}}`,
+			patch: `[{"op": "move", "from": "/a/b", "path": "/c"}]`,
+			want:  `{"a": {}, "c": 1}`,
+		},
+		{
+			name:  "copy is independent",
+			doc:   `{"a": {"b": 1}}`,
+			patch: `[{"op": "copy", "from": "/a", "path": "/c"}, {"op": "add", "path": "/c/d", "value": 2}]`,
+			want:  `{"a": {"b": 1}, "c": {"b": 1, "d": 2}}`,
+		},
+		{
+			name:  "escaped pointer",
+			doc:   `{"a/b": {"c~d": 1}}`,
+			patch: `[{"op": "replace", "path": "/a~1b/c~0d", "value": 2}]`,
+			want:  `{"a/b": {"c~d": 2}}`,
+		},
+		{
+			name:  "test compares numbers by value",
+			doc:   `{"a": 1.0}`,
+			patch: `[{"op": "test", "path": "/a", "value": 1}]`,
+			want:  `{"a": 1.0}`,
+		},
+		{
+			name:  "test fails",
+			doc:   `{"a": 1}`,
+			patch: `[{"op": "test", "path": "/a", "value": 2}]`,
+			err:   `patch test failed: value at "/a" does not match`,
+		},
+		{
+			name:  "remove whole document",
+			doc:   `{"a": 1}`,
+			patch: `[{"op": "remove", "path": ""}]`,
+			err:   "cannot remove the whole document",
+		},
+		{
+			name:  "move into child",
+			doc:   `{"a": {"b": 1}}`,
+			patch: `[{"op": "move", "from": "/a", "path": "/a/c"}]`,
+			err:   "into one of its children",
+		},
+		{
+			name:  "missing key",
+			doc:   `{"a": 1}`,
+			patch: `[{"op": "remove", "path": "/b"}]`,
+			err:   `key "b" not found`,
+		},
+		{
+			name:  "index out of bounds",
+			doc:   `{"l": [1]}`,
+			patch: `[{"op": "add", "path": "/l/2", "value": 1}]`,
+			err:   "out of bounds",
+		},
+		{
+			name:  "leading zero index",
+			doc:   `{"l": [1, 2]}`,
+			patch: `[{"op": "remove", "path": "/l/01"}]`,
+			err:   "invalid array index",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mustJSONPatch(t, tt.patch).apply(mustDecode(t, tt.doc))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := mustDecode(t, tt.want); !jsonEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	target := mustDecode(t, `{"a": {"b": 1, "c": 2}, "d": [1], "e": "x"}`)
+	patch := mustDecode(t, `{"a": {"b": null, "f": 3}, "d": [2, 3], "e": {"g": 1}}`)
+
+	got := ApplyMergePatch(target, patch)
+	want := mustDecode(t, `{"a": {"c": 2, "f": 3}, "d": [2, 3], "e": {"g": 1}}`)
+	if !jsonEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestApplyMergePatchCopiesValues(t *testing.T) {
+	patch := mustDecode(t, `{"l": [1, 2], "m": {"n": [3]}}`)
+	orig := DeepCopy(patch)
+
+	doc := ApplyMergePatch(map[string]interface{}{}, patch)
+	doc.(map[string]interface{})["l"].([]interface{})[0] = "changed"
+	doc.(map[string]interface{})["m"].(map[string]interface{})["n"].([]interface{})[0] = "changed"
+
+	if !reflect.DeepEqual(patch, orig) {
+		t.Errorf("patch changed to %v", patch)
+	}
+}
+
+func TestPatchConfigIsRepeatable(t *testing.T) {
+	// A merge patch adding a list followed by a JSON Patch changing it must
+	// give the same result every time, as prepare applies patches repeatedly.
+	patches := []Patch{
+		&mergePatch{patch: mustDecode(t, `{"l": [1, 2, 3]}`)},
+		mustJSONPatch(t, `[{"op": "remove", "path": "/l/0"}, {"op": "add", "path": "/l/-", "value": 4}]`),
+	}
+
+	for i := 0; i < 3; i++ {
+		out, err := patchConfig("a: 1\n", patches, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "a: 1\nl:\n- 2\n- 3\n- 4\n"; string(out) != want {
+			t.Fatalf("run %d: got %q, want %q", i, out, want)
+		}
+	}
+}
+
+func TestPatchConfig(t *testing.T) {
+	patches := []Patch{mustJSONPatch(t, `[{"op": "replace", "path": "/image/tag", "value": "v2"}]`)}
+	overrides := map[string]interface{}{"replicas": 3}
+
+	out, err := patchConfig("image:\n  tag: v1\nbig: 12345678901234567890\n", patches, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "big: 12345678901234567890\nimage:\n  tag: v2\nreplicas: 3\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	_, err = patchConfig("a: 1\n", []Patch{mustJSONPatch(t, `[{"op": "replace", "path": "", "value": [1]}]`)}, nil)
+	if err == nil || !strings.Contains(err.Error(), "must be a map") {
+		t.Errorf("got error %v, want a map error", err)
+	}
+}
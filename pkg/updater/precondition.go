@@ -0,0 +1,74 @@
+package updater
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Precondition is a check on the current values of a release which must hold
+// for the update to proceed.
+type Precondition struct {
+	Path  string
+	Value string
+	// Absent requires that no value is set at Path, Value is ignored.
+	Absent bool
+}
+
+// PreconditionError is returned when one or more preconditions do not hold.
+type PreconditionError struct {
+	Failures []string
+}
+
+func (e *PreconditionError) Error() string {
+	return "precondition failed: " + strings.Join(e.Failures, "; ")
+}
+
+// ParsePrecondition parses a "path=value" precondition.
+func ParsePrecondition(s string) (Precondition, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return Precondition{}, fmt.Errorf("invalid precondition %q: expected path=value", s)
+	}
+
+	return Precondition{Path: s[:i], Value: s[i+1:]}, nil
+}
+
+// check returns a description of the failure, or an empty string if the
+// precondition holds. Sensitive values are not included in the description.
+func (p Precondition) check(vals map[string]interface{}, r *Redactor) string {
+	v, ok := LookupValue(vals, p.Path)
+
+	if p.Absent {
+		if ok {
+			return fmt.Sprintf("%s is set to %q, expected it to be absent", p.Path, FormatValue(r.Value(p.Path, v)))
+		}
+		return ""
+	}
+
+	if !ok {
+		return fmt.Sprintf("%s is not set, expected %q", p.Path, FormatValue(r.Value(p.Path, p.Value)))
+	}
+	if got := FormatValue(v); got != p.Value {
+		if r.Sensitive(p.Path, v) || r.Sensitive(p.Path, p.Value) {
+			return fmt.Sprintf("%s does not have the expected value", p.Path)
+		}
+		return fmt.Sprintf("%s is %q, expected %q", p.Path, got, p.Value)
+	}
+
+	return ""
+}
+
+func checkPreconditions(vals map[string]interface{}, conds []Precondition, r *Redactor) error {
+	var failures []string
+	for _, c := range conds {
+		if f := c.check(vals, r); f != "" {
+			failures = append(failures, f)
+		}
+	}
+
+	if len(failures) > 0 {
+		return &PreconditionError{Failures: failures}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package updater
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePrecondition(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Precondition
+		err  string
+	}{
+		{in: "image.tag=v1", want: Precondition{Path: "image.tag", Value: "v1"}},
+		{in: "replicas=", want: Precondition{Path: "replicas"}},
+		// Only the first = separates the path from the value.
+		{in: "args[0]=--level=debug", want: Precondition{Path: "args[0]", Value: "--level=debug"}},
+		{in: "image.tag", err: `invalid precondition "image.tag": expected path=value`},
+		{in: "=v1", err: `invalid precondition "=v1": expected path=value`},
+	}
+	for _, tt := range tests {
+		got, err := ParsePrecondition(tt.in)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: got error %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	vals := mustDecode(t, `{"image": {"tag": "v1"}, "replicas": 2, "debug": false, "db": {"password": "hunter22"}}`).(map[string]interface{})
+	r := newTestRedactor(t, RedactConfig{})
+
+	tests := []struct {
+		conds []Precondition
+		want  string
+	}{
+		{[]Precondition{{Path: "image.tag", Value: "v1"}, {Path: "replicas", Value: "2"}, {Path: "debug", Value: "false"}}, ""},
+		{[]Precondition{{Path: "image.tag", Value: "v2"}}, `precondition failed: image.tag is "v1", expected "v2"`},
+		{[]Precondition{{Path: "image.digest", Value: "sha256:0123"}}, `precondition failed: image.digest is not set, expected "sha256:0123"`},
+		{[]Precondition{{Path: "image.digest", Absent: true}}, ""},
+		{[]Precondition{{Path: "image.tag", Absent: true}}, `precondition failed: image.tag is set to "v1", expected it to be absent`},
+		// Every failure is reported.
+		{
+			[]Precondition{{Path: "image.tag", Value: "v2"}, {Path: "replicas", Value: "2"}, {Path: "debug", Value: "true"}},
+			`precondition failed: image.tag is "v1", expected "v2"; debug is "false", expected "true"`,
+		},
+		// Sensitive values are not quoted.
+		{[]Precondition{{Path: "db.password", Value: "hunter22"}}, ""},
+		{[]Precondition{{Path: "db.password", Value: "hunter2"}}, "precondition failed: db.password does not have the expected value"},
+		{[]Precondition{{Path: "db.password", Absent: true}}, `precondition failed: db.password is set to "<redacted>", expected it to be absent`},
+	}
+	for _, tt := range tests {
+		err := checkPreconditions(vals, tt.conds, r)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%+v: %s", tt.conds, err)
+			}
+			continue
+		}
+		if _, ok := err.(*PreconditionError); !ok || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%+v: got error %v, want %q", tt.conds, err, tt.want)
+		}
+		if err != nil && strings.Contains(err.Error(), "hunter2") {
+			t.Errorf("%+v: error %q quotes the password", tt.conds, err)
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package updater
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+const redacted = "<redacted>"
+
+// defaultRedactPatterns match key paths which usually hold secrets.
+var defaultRedactPatterns = []string{
+	"*password*",
+	"*passwd*",
+	"*secret*",
+	"*token*",
+	"*credential*",
+	"*apikey*",
+	"*api_key*",
+	"*privatekey*",
+	"*private_key*",
+	"*.tls.key",
+}
+
+// RedactConfig configures which values are masked in output.
+type RedactConfig struct {
+	// Patterns are globs matched against lower-cased dot-separated key paths.
+	// They are used in addition to the default patterns.
+	Patterns          []string `json:"patterns"`
+	NoDefaultPatterns bool     `json:"noDefaultPatterns"`
+	// NoValueDetection turns off masking of values that look like tokens or
+	// PEM blocks regardless of their key.
+	NoValueDetection bool `json:"noValueDetection"`
+	// Key is the secret the fingerprints of masked values are computed with,
+	// so that they cannot be reversed by hashing guesses. A random key is
+	// used if it is empty, and fingerprints then only compare within a run.
+	Key []byte `json:"-"`
+}
+
+// Redactor masks sensitive values. It is used by every command that prints,
+// logs or stores values of a release outside of Tiller.
+type Redactor struct {
+	patterns       []glob.Glob
+	valueDetection bool
+	key            []byte
+}
+
+func NewRedactor(cfg RedactConfig) (*Redactor, error) {
+	r := &Redactor{valueDetection: !cfg.NoValueDetection, key: cfg.Key}
+	if len(r.key) == 0 {
+		r.key = make([]byte, 32)
+		if _, err := rand.Read(r.key); err != nil {
+			return nil, fmt.Errorf("generating redaction key: %s", err)
+		}
+	}
+
+	patterns := cfg.Patterns
+	if !cfg.NoDefaultPatterns {
+		patterns = append(append([]string(nil), defaultRedactPatterns...), patterns...)
+	}
+
+	for _, p := range patterns {
+		g, err := glob.Compile(strings.ToLower(p))
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %s", p, err)
+		}
+		r.patterns = append(r.patterns, g)
+	}
+
+	return r, nil
+}
+
+// sensitiveKey reports whether a dot-separated key path matches a redact pattern.
+func (r *Redactor) sensitiveKey(path string) bool {
+	path = strings.ToLower(path)
+	for _, g := range r.patterns {
+		if g.Match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	tokenRe  = regexp.MustCompile(`^[A-Za-z0-9+/=_.\-]{20,}$`)
+	digestRe = regexp.MustCompile(`(^|@)sha256:[0-9a-f]{64}$`)
+)
+
+// sensitiveValue reports whether a value looks like a secret by itself: a PEM
+// block or a long, high-entropy token. Image digests are not considered secret.
+func (r *Redactor) sensitiveValue(v interface{}) bool {
+	s, ok := v.(string)
+	if !ok || !r.valueDetection {
+		return false
+	}
+
+	if strings.Contains(s, "-----BEGIN ") {
+		return true
+	}
+
+	if !tokenRe.MatchString(s) || digestRe.MatchString(s) {
+		return false
+	}
+
+	return strings.ContainsAny(s, "0123456789") && entropy(s) >= 4.2
+}
+
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, c := range s {
+		counts[c]++
+	}
+
+	var e float64
+	n := float64(len(s))
+	for _, c := range counts {
+		p := float64(c) / n
+		e -= p * math.Log2(p)
+	}
+
+	return e
+}
+
+// Sensitive reports whether the value at path has to be masked, or for maps
+// and lists whether anything in them has to be.
+func (r *Redactor) Sensitive(path string, v interface{}) bool {
+	_, masked := r.redact(path, v, func(v interface{}) interface{} { return v })
+	return masked
+}
+
+// redact returns a copy of v with every sensitive value replaced by mask, and
+// whether anything was replaced. Maps and lists are descended into, the
+// elements of a list being at path[i]. In a list of name/value pairs, like
+// the env of a container, a value is sensitive if its name is.
+func (r *Redactor) redact(path string, v interface{}, mask func(interface{}) interface{}) (interface{}, bool) {
+	if path != "" && r.sensitiveKey(path) {
+		return mask(v), true
+	}
+
+	var masked bool
+	switch node := v.(type) {
+	case map[string]interface{}:
+		name, _ := node["name"].(string)
+		out := make(map[string]interface{}, len(node))
+		for k, sub := range node {
+			if k == "value" && name != "" && r.sensitiveKey(name) {
+				out[k], masked = mask(sub), true
+				continue
+			}
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			var m bool
+			out[k], m = r.redact(p, sub, mask)
+			masked = masked || m
+		}
+		return out, masked
+	case []interface{}:
+		out := make([]interface{}, len(node))
+		for i, sub := range node {
+			var m bool
+			out[i], m = r.redact(fmt.Sprintf("%s[%d]", path, i), sub, mask)
+			masked = masked || m
+		}
+		return out, masked
+	}
+
+	if r.sensitiveValue(v) {
+		return mask(v), true
+	}
+	return v, false
+}
+
+// fingerprint returns a short keyed hash of a value, so that redacted values
+// can still be told apart without giving away what they are.
+func (r *Redactor) fingerprint(v interface{}) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(FormatValue(v)))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+// Value returns a copy of v with sensitive values replaced by a placeholder.
+func (r *Redactor) Value(path string, v interface{}) interface{} {
+	out, _ := r.redact(path, v, func(interface{}) interface{} { return redacted })
+	return out
+}
+
+// Values returns a copy of vals with sensitive values replaced by a
+// placeholder.
+func (r *Redactor) Values(vals map[string]interface{}) map[string]interface{} {
+	return r.Value("", vals).(map[string]interface{})
+}
+
+// Changes replaces sensitive old and new values by their fingerprints.
+func (r *Redactor) Changes(changes []Change) []Change {
+	out := make([]Change, len(changes))
+	for i, c := range changes {
+		if r.Sensitive(c.Key, c.Old) || r.Sensitive(c.Key, c.New) {
+			c.Redacted = true
+			if c.Old != nil {
+				c.Old = r.fingerprint(c.Old)
+			}
+			if c.New != nil {
+				c.New = r.fingerprint(c.New)
+			}
+		}
+		out[i] = c
+	}
+	return out
+}
+
+// SetFlag masks sensitive values in a --set style argument.
+func (r *Redactor) SetFlag(s string) string {
+	parts := splitSetFlag(s)
+	for i, p := range parts {
+		if eq := strings.Index(p, "="); eq > 0 && r.Sensitive(p[:eq], p[eq+1:]) {
+			parts[i] = p[:eq+1] + redacted
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// splitSetFlag splits a --set argument into its key=value pairs at commas
+// which are neither escaped nor inside a {list}.
+func splitSetFlag(s string) []string {
+	var (
+		parts []string
+		depth int
+		start int
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+var yamlLineRe = regexp.MustCompile(`^(\s*(?:-\s+)?)([^\s:#][^:]*?):(\s+)(\S.*)$`)
+
+// Manifest masks sensitive values in rendered manifests: values of sensitive
+// keys, values that look like secrets, PEM blocks and the data of Secret
+// objects. Only the last key of a path is known here, so key patterns are
+// matched against it alone. Masked values are replaced by their fingerprints,
+// so a diff of two masked manifests still shows which values changed.
+func (r *Redactor) Manifest(s string) string {
+	var (
+		lines        = strings.Split(s, "\n")
+		inSecret     bool
+		inSecretData bool
+		inPEM        bool
+		blockIndent  = -1
+		// Set after "- name: DB_PASSWORD" of an env var until its value.
+		sensitiveEnv bool
+	)
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+
+		// Continuation lines of a masked block scalar.
+		if blockIndent >= 0 {
+			if trimmed == "" || indent > blockIndent {
+				if trimmed != "" {
+					lines[i] = line[:indent] + r.redactedHash(trimmed)
+				}
+				continue
+			}
+			blockIndent = -1
+		}
+
+		if inPEM || (r.valueDetection && strings.HasPrefix(trimmed, "-----BEGIN ")) {
+			inPEM = !strings.HasPrefix(trimmed, "-----END ")
+			lines[i] = line[:indent] + r.redactedHash(trimmed)
+			continue
+		}
+
+		switch {
+		case trimmed == "---":
+			inSecret, inSecretData = false, false
+		case indent == 0 && trimmed != "" && !strings.HasPrefix(trimmed, "#"):
+			if trimmed == "kind: Secret" {
+				inSecret = true
+			}
+			inSecretData = trimmed == "data:" || trimmed == "stringData:"
+		}
+
+		m := yamlLineRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		key, value := m[2], strings.Trim(m[4], `"'`)
+		envValue := sensitiveEnv && key == "value"
+		sensitiveEnv = key == "name" && r.sensitiveKey(value)
+		if !envValue && !(inSecret && inSecretData && indent > 0) && !r.Sensitive(key, value) {
+			continue
+		}
+
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockIndent = indent
+			continue
+		}
+		lines[i] = m[1] + key + ":" + m[3] + r.redactedHash(value)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (r *Redactor) redactedHash(v interface{}) string {
+	return "<redacted " + r.fingerprint(v) + ">"
+}
+
+var (
+	pemBlockRe = regexp.MustCompile(`-----BEGIN [^-]+-----[\s\S]*?-----END [^-]+-----`)
+	wordRe     = regexp.MustCompile(`[A-Za-z0-9+/=_.\-]{20,}`)
+)
+
+// minTextSecret is the length below which sensitive values are not searched
+// for in text, as they would match all over it.
+const minTextSecret = 4
+
+// Text masks secrets in free text, such as the message of an error returned
+// by Tiller: the sensitive values of vals wherever they occur, PEM blocks and
+// words that look like tokens.
+func (r *Redactor) Text(s string, vals ...map[string]interface{}) string {
+	var secrets []string
+	for _, v := range vals {
+		r.redact("", v, func(v interface{}) interface{} {
+			secrets = appendScalars(secrets, v)
+			return v
+		})
+	}
+	// Longer values first, in case one contains another.
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	for _, secret := range secrets {
+		if len(secret) >= minTextSecret {
+			s = strings.Replace(s, secret, r.redactedHash(secret), -1)
+		}
+	}
+
+	if !r.valueDetection {
+		return s
+	}
+	s = pemBlockRe.ReplaceAllStringFunc(s, func(block string) string { return r.redactedHash(block) })
+	return wordRe.ReplaceAllStringFunc(s, func(word string) string {
+		if r.sensitiveValue(word) {
+			return r.redactedHash(word)
+		}
+		return word
+	})
+}
+
+// appendScalars appends the formatted scalars of v to out.
+func appendScalars(out []string, v interface{}) []string {
+	switch v := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		for _, sub := range v {
+			out = appendScalars(out, sub)
+		}
+	case []interface{}:
+		for _, sub := range v {
+			out = appendScalars(out, sub)
+		}
+	default:
+		out = append(out, FormatValue(v))
+	}
+	return out
+}
+
+// Error returns err with secrets masked in its message, see Text. The errors
+// of this package keep their type.
+func (r *Redactor) Error(err error, vals ...map[string]interface{}) error {
+	if err == nil {
+		return nil
+	}
+	msg := r.Text(err.Error(), vals...)
+	if msg == err.Error() {
+		return err
+	}
+
+	// The causes are masked in turn, so that callers can still tell them
+	// apart, e.g. a *RevisionError from a *PreconditionError.
+	switch e := err.(type) {
+	case *TillerError:
+		return &TillerError{Op: e.Op, Err: r.Error(e.Err, vals...)}
+	case *ValidationError:
+		return &ValidationError{Err: r.Error(e.Err, vals...)}
+	case *ConflictError:
+		return &ConflictError{Release: e.Release, Err: r.Error(e.Err, vals...)}
+	case *RevisionError:
+		return &RevisionError{Release: r.Text(e.Release, vals...), Current: e.Current, Expected: e.Expected}
+	case *PreconditionError:
+		failures := make([]string, len(e.Failures))
+		for i, f := range e.Failures {
+			failures[i] = r.Text(f, vals...)
+		}
+		return &PreconditionError{Failures: failures}
+	case *MissingImagesError:
+		images := make([]string, len(e.Images))
+		for i, img := range e.Images {
+			images[i] = r.Text(img, vals...)
+		}
+		return &MissingImagesError{Images: images}
+	}
+	return errors.New(msg)
+}
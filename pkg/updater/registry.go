@@ -0,0 +1,416 @@
+package updater
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dockerHub         = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+	dockerHubAuthKey  = "https://index.docker.io/v1/"
+)
+
+// manifestMediaTypes are accepted when fetching manifests, so that the
+// registry returns the digest of the image index for multi-arch images.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.v1+prettyjws",
+}
+
+// Registry talks to container registries through the OCI distribution API.
+// Credentials are taken from the docker config file. Results are cached, so
+// a Registry is meant to be used for a single run.
+type Registry struct {
+	client *http.Client
+
+	mu        sync.Mutex
+	config    *dockerConfig
+	tokens    map[string]string
+	manifests map[string]manifestResult
+}
+
+type manifestResult struct {
+	digest string
+	found  bool
+	err    error
+}
+
+// NewRegistry returns a Registry using the credentials of the docker config.
+func NewRegistry() *Registry {
+	return &Registry{
+		client:    &http.Client{Timeout: 30 * time.Second},
+		tokens:    make(map[string]string),
+		manifests: make(map[string]manifestResult),
+	}
+}
+
+// Digest returns the digest of the manifest an image reference points to.
+func (r *Registry) Digest(image string) (string, error) {
+	m := r.manifest(image)
+	if m.err != nil {
+		return "", m.err
+	}
+	if !m.found {
+		return "", fmt.Errorf("image %s not found", image)
+	}
+	return m.digest, nil
+}
+
+// Exists reports whether the manifest an image reference points to exists.
+func (r *Registry) Exists(image string) (bool, error) {
+	m := r.manifest(image)
+	return m.found, m.err
+}
+
+func (r *Registry) manifest(image string) manifestResult {
+	r.mu.Lock()
+	m, ok := r.manifests[image]
+	r.mu.Unlock()
+	if ok {
+		return m
+	}
+
+	m = r.fetchManifest(image)
+
+	r.mu.Lock()
+	r.manifests[image] = m
+	r.mu.Unlock()
+
+	return m
+}
+
+func (r *Registry) fetchManifest(image string) manifestResult {
+	ref, err := parseImageRef(image)
+	if err != nil {
+		return manifestResult{err: err}
+	}
+
+	reference := ref.tag
+	if ref.digest != "" {
+		reference = ref.digest
+	}
+	u := fmt.Sprintf("%s/v2/%s/manifests/%s", ref.endpoint(), ref.repository, reference)
+
+	resp, err := r.do("HEAD", u, ref)
+	if err != nil {
+		return manifestResult{err: fmt.Errorf("%s: %s", image, err)}
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return manifestResult{}
+	case resp.StatusCode != http.StatusOK:
+		return manifestResult{err: fmt.Errorf("%s: registry returned %s", image, resp.Status)}
+	}
+
+	if d := resp.Header.Get("Docker-Content-Digest"); d != "" {
+		return manifestResult{digest: d, found: true}
+	}
+
+	// Not every registry sends the digest on HEAD requests, it is the hash
+	// of the manifest then.
+	resp, err = r.do("GET", u, ref)
+	if err != nil {
+		return manifestResult{err: fmt.Errorf("%s: %s", image, err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return manifestResult{err: fmt.Errorf("%s: registry returned %s", image, resp.Status)}
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return manifestResult{err: fmt.Errorf("%s: %s", image, err)}
+	}
+
+	return manifestResult{digest: "sha256:" + hex.EncodeToString(h.Sum(nil)), found: true}
+}
+
+// do sends a request, authenticating with basic auth or a bearer token when
+// the registry asks for it.
+func (r *Registry) do(method, u string, ref imageRef) (*http.Response, error) {
+	send := func(auth string) (*http.Response, error) {
+		req, err := http.NewRequest(method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		return r.client.Do(req)
+	}
+
+	r.mu.Lock()
+	auth := r.tokens[ref.registry+"/"+ref.repository]
+	r.mu.Unlock()
+
+	resp, err := send(auth)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	auth, err = r.authorize(resp.Header.Get("WWW-Authenticate"), ref)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.tokens[ref.registry+"/"+ref.repository] = auth
+	r.mu.Unlock()
+
+	return send(auth)
+}
+
+var authParamRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authorize answers an authentication challenge and returns the value of the
+// Authorization header to retry with.
+func (r *Registry) authorize(challenge string, ref imageRef) (string, error) {
+	user, pass, err := r.credentials(ref.registry)
+	if err != nil {
+		return "", err
+	}
+
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+	switch scheme {
+	case "basic":
+		if user == "" {
+			return "", fmt.Errorf("registry %s requires credentials", ref.registry)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass)), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("registry %s: unsupported authentication %q", ref.registry, challenge)
+	}
+
+	params := make(map[string]string)
+	for _, m := range authParamRe.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("registry %s: no realm in %q", ref.registry, challenge)
+	}
+
+	q := url.Values{}
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + ref.repository + ":pull"
+	}
+	q.Set("scope", scope)
+
+	req, err := http.NewRequest("GET", params["realm"]+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry %s: getting a token failed: %s", ref.registry, resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("registry %s: reading token: %s", ref.registry, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+
+	return "Bearer " + token.Token, nil
+}
+
+// dockerConfig is the part of ~/.docker/config.json holding credentials.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+func loadDockerConfig() *dockerConfig {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".docker")
+	}
+
+	c := &dockerConfig{}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "config.json")); err == nil {
+		json.Unmarshal(data, c)
+	}
+	return c
+}
+
+// credentials returns the user name and password for a registry from the
+// docker config, asking a credential helper if one is configured.
+func (r *Registry) credentials(registry string) (string, string, error) {
+	r.mu.Lock()
+	if r.config == nil {
+		r.config = loadDockerConfig()
+	}
+	c := r.config
+	r.mu.Unlock()
+
+	keys := []string{registry, "https://" + registry, "http://" + registry}
+	if registry == dockerHub {
+		keys = []string{dockerHubAuthKey, "index.docker.io", dockerHub}
+	}
+
+	helper := c.CredsStore
+	for _, k := range keys {
+		if h, ok := c.CredHelpers[k]; ok {
+			helper = h
+		}
+	}
+
+	for _, k := range keys {
+		a, ok := c.Auths[k]
+		if !ok {
+			continue
+		}
+		if a.Auth != "" {
+			data, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				return "", "", fmt.Errorf("docker config: invalid auth for %s", k)
+			}
+			parts := strings.SplitN(string(data), ":", 2)
+			if len(parts) == 2 {
+				return parts[0], parts[1], nil
+			}
+		}
+		if a.Username != "" {
+			return a.Username, a.Password, nil
+		}
+	}
+
+	if helper != "" {
+		return credentialHelper(helper, keys[0])
+	}
+
+	return "", "", nil
+}
+
+func credentialHelper(helper, server string) (string, string, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = bytes.NewBufferString(server)
+
+	out, err := cmd.Output()
+	if err != nil {
+		// Helpers fail for servers they have no credentials for.
+		return "", "", nil
+	}
+
+	var cred struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(out, &cred); err != nil {
+		return "", "", fmt.Errorf("docker-credential-%s: %s", helper, err)
+	}
+
+	return cred.Username, cred.Secret, nil
+}
+
+// imageRef is a parsed image reference like registry:5000/team/app:v1.
+type imageRef struct {
+	registry   string
+	repository string
+	tag        string
+	digest     string
+}
+
+func parseImageRef(s string) (imageRef, error) {
+	var ref imageRef
+
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.digest = name[:i], name[i+1:]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.tag = name[:i], name[i+1:]
+	}
+	if ref.tag == "" && ref.digest == "" {
+		ref.tag = "latest"
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.registry, ref.repository = parts[0], parts[1]
+	} else {
+		ref.registry, ref.repository = dockerHub, name
+	}
+
+	// docker.io/nginx is the same image as nginx, official images live in
+	// the library namespace.
+	if ref.registry == "index.docker.io" {
+		ref.registry = dockerHub
+	}
+	if ref.registry == dockerHub && ref.repository != "" && !strings.Contains(ref.repository, "/") {
+		ref.repository = "library/" + ref.repository
+	}
+
+	if ref.repository == "" || strings.ToLower(ref.repository) != ref.repository {
+		return ref, fmt.Errorf("invalid image reference %q", s)
+	}
+
+	return ref, nil
+}
+
+// endpoint is the base URL of the registry API. Registries on the local
+// machine are talked to without TLS, like docker does.
+func (ref imageRef) endpoint() string {
+	if ref.registry == dockerHub {
+		return "https://" + dockerHubRegistry
+	}
+
+	host := ref.registry
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return "http://" + ref.registry
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return "http://" + ref.registry
+	}
+
+	return "https://" + ref.registry
+}
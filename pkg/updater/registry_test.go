@@ -0,0 +1,199 @@
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testIndexDigest    = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	testManifestDigest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+// newTestRegistry returns a Registry using the given docker config instead of
+// the one of the user.
+func newTestRegistry(t *testing.T, config string) *Registry {
+	t.Helper()
+	r := NewRegistry()
+	r.config = &dockerConfig{}
+	if err := json.Unmarshal([]byte(config), r.config); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// serveManifest answers like a registry with a multi-arch image: the digest
+// of the index when the client accepts it, the one of the platform manifest
+// otherwise.
+func serveManifest(w http.ResponseWriter, req *http.Request) {
+	if strings.Contains(req.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+		w.Header().Set("Docker-Content-Digest", testIndexDigest)
+	} else {
+		w.Header().Set("Docker-Content-Digest", testManifestDigest)
+	}
+}
+
+func TestRegistryBearerToken(t *testing.T) {
+	var tokens int
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		tokens++
+		user, pass, _ := req.BasicAuth()
+		q := req.URL.Query()
+		if user != "ci" || pass != "secret" || q.Get("service") != "test" || q.Get("scope") != "repository:team/app:pull" {
+			http.Error(w, "bad token request "+req.URL.RawQuery, http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"access_token": "t0ken"}`)
+	})
+	mux.HandleFunc("/v2/team/app/manifests/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer t0ken" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		serveManifest(w, req)
+	})
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	r := newTestRegistry(t, `{"auths": {"`+host+`": {"username": "ci", "password": "secret"}}}`)
+
+	for _, tag := range []string{"v1", "v2"} {
+		digest, err := r.Digest(host + "/team/app:" + tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest != testIndexDigest {
+			t.Errorf("got digest %s of %s, want the digest of the index", digest, tag)
+		}
+	}
+	if tokens != 1 {
+		t.Errorf("got %d token requests, want the token to be reused", tokens)
+	}
+}
+
+func TestRegistryBasicAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, pass, ok := req.BasicAuth(); !ok || user != "ci" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		serveManifest(w, req)
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	image := host + "/app:v1"
+
+	// "ci:secret" in the format of docker login.
+	r := newTestRegistry(t, `{"auths": {"http://`+host+`": {"auth": "Y2k6c2VjcmV0"}}}`)
+	if digest, err := r.Digest(image); err != nil || digest != testIndexDigest {
+		t.Errorf("got %s, %v", digest, err)
+	}
+
+	r = newTestRegistry(t, `{}`)
+	if _, err := r.Digest(image); err == nil || !strings.Contains(err.Error(), "requires credentials") {
+		t.Errorf("got error %v without credentials", err)
+	}
+}
+
+func TestRegistryManifestGet(t *testing.T) {
+	const body = `{"schemaVersion": 2, "manifests": []}`
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		methods = append(methods, req.Method)
+		switch req.URL.Path {
+		case "/v2/app/manifests/v1":
+			// No Docker-Content-Digest header, like some registries do.
+			fmt.Fprint(w, body)
+		case "/v2/app/manifests/broken":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	r := newTestRegistry(t, `{}`)
+
+	sum := sha256.Sum256([]byte(body))
+	digest, err := r.Digest(host + "/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "sha256:" + hex.EncodeToString(sum[:]); digest != want {
+		t.Errorf("got digest %s, want %s", digest, want)
+	}
+	if strings.Join(methods, ",") != "HEAD,GET" {
+		t.Errorf("got requests %v, want HEAD and GET", methods)
+	}
+
+	// Results are cached.
+	r.Digest(host + "/app:v1")
+	if len(methods) != 2 {
+		t.Errorf("manifest was fetched again: %v", methods)
+	}
+
+	if ok, err := r.Exists(host + "/app:v2"); ok || err != nil {
+		t.Errorf("got %v, %v for a missing tag", ok, err)
+	}
+	if _, err := r.Digest(host + "/app:v2"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("got error %v for a missing tag", err)
+	}
+	if _, err := r.Exists(host + "/app:broken"); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("got error %v for a failing registry", err)
+	}
+}
+
+func TestParseImageRef(t *testing.T) {
+	tests := map[string]imageRef{
+		"nginx":                      {registry: "docker.io", repository: "library/nginx", tag: "latest"},
+		"nginx:1.25":                 {registry: "docker.io", repository: "library/nginx", tag: "1.25"},
+		"bitnami/redis:7":            {registry: "docker.io", repository: "bitnami/redis", tag: "7"},
+		"docker.io/nginx:1.25":       {registry: "docker.io", repository: "library/nginx", tag: "1.25"},
+		"index.docker.io/nginx":      {registry: "docker.io", repository: "library/nginx", tag: "latest"},
+		"docker.io/bitnami/redis":    {registry: "docker.io", repository: "bitnami/redis", tag: "latest"},
+		"localhost/app":              {registry: "localhost", repository: "app", tag: "latest"},
+		"registry:5000/team/app:v1":  {registry: "registry:5000", repository: "team/app", tag: "v1"},
+		"ghcr.io/org/app@sha256:abc": {registry: "ghcr.io", repository: "org/app", digest: "sha256:abc"},
+	}
+	for s, want := range tests {
+		got, err := parseImageRef(s)
+		if err != nil {
+			t.Errorf("parseImageRef(%q): %s", s, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("parseImageRef(%q) = %+v, want %+v", s, got, want)
+		}
+	}
+
+	if _, err := parseImageRef("ghcr.io/Org/App"); err == nil {
+		t.Error("reference with upper case letters was accepted")
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	tests := map[string]string{
+		"docker.io":      "https://registry-1.docker.io",
+		"ghcr.io":        "https://ghcr.io",
+		"localhost:5000": "http://localhost:5000",
+		"127.0.0.1:5000": "http://127.0.0.1:5000",
+	}
+	for registry, want := range tests {
+		if got := (imageRef{registry: registry}).endpoint(); got != want {
+			t.Errorf("endpoint of %s = %s, want %s", registry, got, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package updater
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// Parts of a version BumpVersion can increment.
+const (
+	BumpMajor = "major"
+	BumpMinor = "minor"
+	BumpPatch = "patch"
+)
+
+// BumpVersion increments a part of a semantic version. A "v" prefix is kept.
+func BumpVersion(version, part string) (string, error) {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a semantic version", version)
+	}
+
+	var next semver.Version
+	switch part {
+	case BumpMajor:
+		next = v.IncMajor()
+	case BumpMinor:
+		next = v.IncMinor()
+	case BumpPatch:
+		next = v.IncPatch()
+	default:
+		return "", fmt.Errorf("unknown version part %q", part)
+	}
+
+	return next.Original(), nil
+}
+
+var semverRe = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(-[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?(\+[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`)
+
+var prereleaseIDRe = regexp.MustCompile(`^[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*$`)
+
+// IsSemver reports whether s is a complete semantic version, optionally
+// prefixed with "v". Partial versions like "1.2" are not.
+func IsSemver(s string) bool {
+	return semverRe.MatchString(s)
+}
+
+// BumpPrerelease returns the next prerelease of a version with identifier
+// id. A prerelease already bumping part is counted up, so 1.2.4-rc.1 becomes
+// 1.2.4-rc.2 for patch and 2.0.0-rc.1 becomes 2.0.0-rc.2 for major. Any other
+// version is bumped first, so 1.2.3 and 1.2.4-rc.1 become 2.0.0-rc.1 for
+// major. A "v" prefix is kept.
+func BumpPrerelease(version, part, id string) (string, error) {
+	// The semver package only checks the start of a prerelease.
+	if !prereleaseIDRe.MatchString(id) {
+		return "", fmt.Errorf("invalid prerelease identifier %q", id)
+	}
+
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a semantic version", version)
+	}
+
+	if pre := v.Prerelease(); pre != "" && prereleaseBumps(v, part) {
+		n := 0
+		if pre != id {
+			if !strings.HasPrefix(pre, id+".") {
+				// A prerelease of the same version with another identifier.
+				return setPrerelease(*v, id+".1")
+			}
+			if n, err = strconv.Atoi(pre[len(id)+1:]); err != nil {
+				return "", fmt.Errorf("cannot count up prerelease %q of %s", pre, version)
+			}
+		}
+		return setPrerelease(*v, id+"."+strconv.Itoa(n+1))
+	}
+
+	bumped, err := BumpVersion(version, part)
+	if err != nil {
+		return "", err
+	}
+	v, err = semver.NewVersion(bumped)
+	if err != nil {
+		return "", err
+	}
+	return setPrerelease(*v, id+".1")
+}
+
+// prereleaseBumps reports whether the prerelease v is one of a version with
+// part bumped: every prerelease is one of the next patch, but only x.y.0 ones
+// are of the next minor and x.0.0 ones of the next major.
+func prereleaseBumps(v *semver.Version, part string) bool {
+	switch part {
+	case BumpMajor:
+		return v.Minor() == 0 && v.Patch() == 0
+	case BumpMinor:
+		return v.Patch() == 0
+	}
+	return true
+}
+
+func setPrerelease(v semver.Version, pre string) (string, error) {
+	next, err := v.SetPrerelease(pre)
+	if err != nil {
+		return "", fmt.Errorf("invalid prerelease %q: %s", pre, err)
+	}
+	return next.Original(), nil
+}
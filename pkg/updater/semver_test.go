@@ -0,0 +1,89 @@
+package updater
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBumpVersion(t *testing.T) {
+	tests := []struct {
+		version, part, want string
+	}{
+		{"1.2.3", BumpPatch, "1.2.4"},
+		{"1.2.3", BumpMinor, "1.3.0"},
+		{"v1.2.3", BumpMajor, "v2.0.0"},
+		{"1.2.3+build.5", BumpPatch, "1.2.4"},
+		// A prerelease is released by a patch bump.
+		{"1.2.4-rc.1", BumpPatch, "1.2.4"},
+	}
+	for _, tt := range tests {
+		got, err := BumpVersion(tt.version, tt.part)
+		if err != nil {
+			t.Errorf("BumpVersion(%q, %s): %s", tt.version, tt.part, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("BumpVersion(%q, %s) = %q, want %q", tt.version, tt.part, got, tt.want)
+		}
+	}
+
+	if _, err := BumpVersion("latest", BumpPatch); err == nil || !strings.Contains(err.Error(), "not a semantic version") {
+		t.Errorf("got error %v for latest", err)
+	}
+	if _, err := BumpVersion("1.2.3", "build"); err == nil {
+		t.Error("unknown part was accepted")
+	}
+}
+
+func TestBumpPrerelease(t *testing.T) {
+	tests := []struct {
+		version, part, id, want string
+	}{
+		{"1.2.3", BumpPatch, "rc", "1.2.4-rc.1"},
+		{"v1.2.3", BumpMinor, "rc", "v1.3.0-rc.1"},
+		{"1.2.3", BumpMajor, "beta", "2.0.0-beta.1"},
+		{"1.2.4-rc.1", BumpPatch, "rc", "1.2.4-rc.2"},
+		{"1.2.4-rc.9", BumpPatch, "rc", "1.2.4-rc.10"},
+		{"1.2.4-beta.3", BumpPatch, "rc", "1.2.4-rc.1"},
+		{"1.2.4-rc", BumpPatch, "rc", "1.2.4-rc.1"},
+		// The part is honored for prereleases that do not bump it yet.
+		{"1.2.4-rc.1", BumpMajor, "rc", "2.0.0-rc.1"},
+		{"1.2.4-rc.1", BumpMinor, "rc", "1.3.0-rc.1"},
+		{"1.3.0-rc.1", BumpMinor, "rc", "1.3.0-rc.2"},
+		{"1.3.0-rc.1", BumpMajor, "rc", "2.0.0-rc.1"},
+		{"2.0.0-rc.1", BumpMajor, "rc", "2.0.0-rc.2"},
+		{"2.0.0-alpha.4", BumpMajor, "beta", "2.0.0-beta.1"},
+	}
+	for _, tt := range tests {
+		got, err := BumpPrerelease(tt.version, tt.part, tt.id)
+		if err != nil {
+			t.Errorf("BumpPrerelease(%q, %s, %s): %s", tt.version, tt.part, tt.id, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("BumpPrerelease(%q, %s, %s) = %q, want %q", tt.version, tt.part, tt.id, got, tt.want)
+		}
+	}
+
+	if _, err := BumpPrerelease("1.2.4-rc.x", BumpPatch, "rc"); err == nil || !strings.Contains(err.Error(), "cannot count up") {
+		t.Errorf("got error %v for a prerelease without a number", err)
+	}
+	if _, err := BumpPrerelease("1.2.3", BumpPatch, "rc!"); err == nil || !strings.Contains(err.Error(), "invalid prerelease") {
+		t.Errorf("got error %v for an invalid identifier", err)
+	}
+}
+
+func TestIsSemver(t *testing.T) {
+	for s, want := range map[string]bool{
+		"1.2.3":             true,
+		"v1.2.3-rc.1+build": true,
+		"1.2":               false,
+		"01.2.3":            false,
+		"latest":            false,
+		"1.2.3-":            false,
+	} {
+		if got := IsSemver(s); got != want {
+			t.Errorf("IsSemver(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
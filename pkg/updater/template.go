@@ -0,0 +1,138 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// valueTemplate computes a value from the current values of a release.
+type valueTemplate struct {
+	key  string
+	tmpl *template.Template
+}
+
+// parseValueTemplate parses a "key=template" argument of --set-tpl.
+func parseValueTemplate(s string) (valueTemplate, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return valueTemplate{}, fmt.Errorf("invalid template value %q: expected key=template", s)
+	}
+
+	key := s[:i]
+	tmpl, err := template.New(key).
+		Option("missingkey=error").
+		Funcs(templateFuncs).
+		Parse(s[i+1:])
+	if err != nil {
+		return valueTemplate{}, err
+	}
+
+	return valueTemplate{key: key, tmpl: tmpl}, nil
+}
+
+// render executes the template with .Values bound to vals and returns the
+// result typed like a --set value.
+func (t valueTemplate) render(vals map[string]interface{}) (interface{}, error) {
+	var b bytes.Buffer
+	if err := t.tmpl.Execute(&b, map[string]interface{}{"Values": vals}); err != nil {
+		return nil, err
+	}
+	return ParseValue(b.String()), nil
+}
+
+// templateFuncs are the functions available to value templates. They only
+// compute values, none of them has access to the environment or files.
+// Functions taking the value to work on take it last, so they can be used in
+// pipelines.
+var templateFuncs = template.FuncMap{
+	"add": arithmetic(func(a, b float64) (float64, error) { return a + b, nil }),
+	"sub": arithmetic(func(a, b float64) (float64, error) { return a - b, nil }),
+	"mul": arithmetic(func(a, b float64) (float64, error) { return a * b, nil }),
+	"div": arithmetic(func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	}),
+	"mod": arithmetic(func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return math.Mod(a, b), nil
+	}),
+	"max": arithmetic(func(a, b float64) (float64, error) { return math.Max(a, b), nil }),
+	"min": arithmetic(func(a, b float64) (float64, error) { return math.Min(a, b), nil }),
+
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"toString":   FormatValue,
+	"default": func(d, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return d
+		}
+		return v
+	},
+
+	"bumpMajor": func(v interface{}) (string, error) { return BumpVersion(FormatValue(v), BumpMajor) },
+	"bumpMinor": func(v interface{}) (string, error) { return BumpVersion(FormatValue(v), BumpMinor) },
+	"bumpPatch": func(v interface{}) (string, error) { return BumpVersion(FormatValue(v), BumpPatch) },
+}
+
+// arithmetic turns op into a template function accepting any kind of number.
+// Integral results are returned as integers, so they render without a
+// fraction or exponent.
+func arithmetic(op func(a, b float64) (float64, error)) func(a, b interface{}) (interface{}, error) {
+	return func(a, b interface{}) (interface{}, error) {
+		x, err := toFloat(a)
+		if err != nil {
+			return nil, err
+		}
+		y, err := toFloat(b)
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := op(x, y)
+		if err != nil {
+			return nil, err
+		}
+		if r == math.Trunc(r) && math.Abs(r) < 1<<53 {
+			return int64(r), nil
+		}
+		return r, nil
+	}
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case json.Number:
+		return n.Float64()
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", n)
+		}
+		return f, nil
+	}
+
+	return 0, fmt.Errorf("%v is not a number", v)
+}
@@ -0,0 +1,60 @@
+package updater
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValueTemplate(t *testing.T) {
+	vals := map[string]interface{}{
+		"replicas": 3.0,
+		"image":    map[string]interface{}{"tag": "v1.2.3", "repository": "nginx"},
+		"weight":   mustDecode(t, `2.5`),
+		"empty":    "",
+	}
+
+	tests := []struct {
+		arg  string
+		key  string
+		want interface{}
+		err  string
+	}{
+		{arg: "replicas={{ .Values.replicas | add 2 }}", key: "replicas", want: int64(5)},
+		{arg: "replicas={{ sub (mul .Values.replicas 2) 1 }}", key: "replicas", want: int64(5)},
+		{arg: "half={{ div .Values.replicas 2 }}", key: "half", want: "1.5"}, // typed like --set, which keeps floats as strings
+		{arg: "w={{ .Values.weight | mul 2 }}", key: "w", want: int64(5)},
+		{arg: "rest={{ mod .Values.replicas 2 }}", key: "rest", want: int64(1)},
+		{arg: "most={{ max .Values.replicas 10 }}", key: "most", want: int64(10)},
+		{arg: "image.tag={{ .Values.image.tag | bumpMinor }}", key: "image.tag", want: "v1.3.0"},
+		{arg: "name={{ .Values.image.repository | upper | trimPrefix \"NG\" }}", key: "name", want: "INX"},
+		{arg: "fallback={{ .Values.empty | default \"x\" }}", key: "fallback", want: "x"},
+		{arg: "flag={{ .Values.image.tag | hasPrefix \"v1\" }}", key: "flag", want: true},
+		{arg: "broken", err: "expected key=template"},
+		{arg: "a={{ div .Values.replicas 0 }}", err: "division by zero"},
+		{arg: "a={{ .Values.image.tag | add 1 }}", err: `"v1.2.3" is not a number`},
+		{arg: "a={{ .Values.missing.key }}", err: "missing"},
+		{arg: "a={{ env \"HOME\" }}", err: `function "env" not defined`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			tmpl, err := parseValueTemplate(tt.arg)
+			var got interface{}
+			if err == nil {
+				got, err = tmpl.render(vals)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tmpl.key != tt.key || got != tt.want {
+				t.Errorf("got %s=%#v, want %s=%#v", tmpl.key, got, tt.key, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+// Package updater updates the config values of Helm releases without
+// changing their chart. It is what the update-config plugin is built on.
+package updater
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	yaml "gopkg.in/yaml.v1"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/strvals"
+)
+
+// Options describes a config change of a single release.
+type Options struct {
+	// Release is the name of the release to update.
+	Release string
+	// Values are merged into the config of the release.
+	Values map[string]interface{}
+	// Set are values in the format of helm --set. They take precedence over Values.
+	Set []string
+	// SetTemplates are "key=template" values. The text/template is executed
+	// with .Values bound to the current values of the release, and the
+	// result takes precedence over Set.
+	SetTemplates []string
+	// Patches are applied to the current config, in order, before Values and
+	// Set. The resulting config replaces the current one.
+	Patches []Patch
+	// Preconditions have to hold for the current values of the release.
+	Preconditions []Precondition
+	// IfRevision, unless zero, is the revision the release has to be at.
+	IfRevision int32
+	// ResetValues starts from the values built into the chart. Otherwise the
+	// current config of the release is reused.
+	ResetValues bool
+	// Wait waits until the resources of the release are ready.
+	Wait bool
+	// Timeout is the number of seconds Tiller waits for. When zero, it is
+	// derived from the deadline of the context, if there is one.
+	Timeout int64
+	// DryRun simulates the update without writing a new revision.
+	DryRun bool
+	// Redactor masks sensitive values in the result and in errors. The
+	// default patterns are used if it is nil.
+	Redactor *Redactor
+	// PinDigests replaces the tags of the images in the new values by
+	// tag@digest, so the release keeps running the exact images resolved now.
+	PinDigests bool
+	// ImagePaths are where images are found for PinDigests. By default every
+	// image table with a repository and a tag is pinned.
+	ImagePaths []ImagePath
+	// CheckImages makes sure every image of the new manifest exists in its
+	// registry before the update is made. The manifest is rendered with a
+	// dry run first.
+	CheckImages bool
+	// Registry resolves images for PinDigests and CheckImages. One using the
+	// credentials of the docker config is created if it is nil. Lookups are
+	// cached, so sharing a Registry between updates saves requests.
+	Registry *Registry
+}
+
+// Result describes an update.
+type Result struct {
+	Release          string
+	Namespace        string
+	PreviousRevision int32
+	// Revision is the revision written by the update, or the one a dry run
+	// would have written.
+	Revision int32
+	Status   release.Status_Code
+	// Changes are the changes to the user-supplied config, with sensitive
+	// values redacted.
+	Changes []Change
+	// Config is the complete user-supplied config after the update.
+	Config []byte
+	// Pinned are the images pinned to a digest with PinDigests.
+	Pinned []PinnedImage
+	// Warnings are problems which did not stop the update, like images that
+	// could not be checked.
+	Warnings []string
+	DryRun   bool
+
+	Previous *release.Release
+	Updated  *release.Release
+}
+
+// Update updates the config of a release through Tiller.
+func Update(ctx context.Context, client helm.Interface, opts Options) (*Result, error) {
+	return UpdateBackend(ctx, &TillerBackend{Client: client}, opts)
+}
+
+// UpdateBackend updates the config of a release stored in backend. Once the
+// release has been read, a Result describing it is returned even if the
+// update fails.
+func UpdateBackend(ctx context.Context, backend Backend, opts Options) (*Result, error) {
+	u, err := newUpdate(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := u.run(ctx, backend)
+	if err != nil {
+		return res, u.redactError(err, res)
+	}
+	return res, nil
+}
+
+func (u *update) run(ctx context.Context, backend Backend) (*Result, error) {
+	opts := u.opts
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	current, err := backend.Release(opts.Release)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{
+		Release:          current.Name,
+		Namespace:        current.Namespace,
+		PreviousRevision: current.Version,
+		DryRun:           opts.DryRun,
+		Previous:         current,
+	}
+
+	if opts.IfRevision != 0 && current.Version != opts.IfRevision {
+		return res, &ConflictError{
+			Release: current.Name,
+			Err:     &RevisionError{Release: current.Name, Current: current.Version, Expected: opts.IfRevision},
+		}
+	}
+
+	if err := u.renderTemplates(current); err != nil {
+		return res, err
+	}
+
+	if err := u.checkPreconditions(current); err != nil {
+		// The changes are still of interest to see what was refused.
+		u.prepare(current, res)
+		return res, err
+	}
+
+	if err := u.prepare(current, res); err != nil {
+		return res, err
+	}
+
+	if opts.PinDigests {
+		res.Pinned, err = u.pinDigests(current, res.Config)
+		if err != nil {
+			return res, err
+		}
+		if err := u.prepare(current, res); err != nil {
+			return res, err
+		}
+	}
+
+	req := Request{
+		DryRun:  opts.DryRun,
+		Wait:    opts.Wait,
+		Timeout: opts.Timeout,
+	}
+	if req.Timeout == 0 {
+		if d, ok := ctx.Deadline(); ok {
+			req.Timeout = int64(time.Until(d) / time.Second)
+			if req.Timeout < 1 {
+				req.Timeout = 1
+			}
+		}
+	}
+
+	if len(opts.Patches) > 0 {
+		// Patches produce the complete user-supplied config, so it replaces
+		// the previous one instead of being merged on top of it.
+		req.Values = res.Config
+	} else {
+		req.Values, err = yaml.Marshal(u.values)
+		if err != nil {
+			return res, &ValidationError{Err: err}
+		}
+		req.ReuseValues = !opts.ResetValues
+	}
+
+	var updated *release.Release
+
+	if opts.CheckImages {
+		dryReq := req
+		dryReq.DryRun = true
+		dry, err := backend.Update(current, dryReq)
+		if err != nil {
+			return res, err
+		}
+		warnings, err := CheckImages(u.registry, dry.GetManifest())
+		res.Warnings = append(res.Warnings, warnings...)
+		if err != nil {
+			if _, ok := err.(*MissingImagesError); ok {
+				return res, &ValidationError{Err: err}
+			}
+			return res, err
+		}
+		if opts.DryRun {
+			updated = dry
+		}
+	}
+
+	if updated == nil {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		updated, err = backend.Update(current, req)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	res.Updated = updated
+	res.Revision = updated.GetVersion()
+	res.Status = updated.GetInfo().GetStatus().GetCode()
+
+	return res, nil
+}
+
+// redactError masks the values of the release and of the update in the
+// message of err, which may quote them when it comes from Tiller.
+func (u *update) redactError(err error, res *Result) error {
+	vals := []map[string]interface{}{u.values}
+	if res != nil {
+		if old, err := chartutil.ReadValues([]byte(res.Previous.GetConfig().GetRaw())); err == nil {
+			vals = append(vals, old)
+		}
+		if config, err := chartutil.ReadValues(res.Config); err == nil {
+			vals = append(vals, config)
+		}
+	}
+	return u.redactor.Error(err, vals...)
+}
+
+// update is an update with validated options.
+type update struct {
+	opts      Options
+	values    map[string]interface{}
+	templates []valueTemplate
+	redactor  *Redactor
+	registry  *Registry
+}
+
+func newUpdate(opts Options) (*update, error) {
+	if opts.Release == "" {
+		return nil, &ValidationError{Err: errors.New("release name is required")}
+	}
+	if opts.ResetValues && len(opts.Patches) > 0 {
+		return nil, &ValidationError{Err: errors.New("--reset-values cannot be combined with patch files")}
+	}
+
+	u := &update{opts: opts, redactor: opts.Redactor, registry: opts.Registry}
+	if u.registry == nil {
+		u.registry = NewRegistry()
+	}
+
+	u.values, _ = DeepCopy(opts.Values).(map[string]interface{})
+	if u.values == nil {
+		u.values = make(map[string]interface{})
+	}
+	for _, s := range opts.Set {
+		if err := strvals.ParseInto(s, u.values); err != nil {
+			return nil, &ValidationError{Err: err}
+		}
+	}
+
+	for _, s := range opts.SetTemplates {
+		t, err := parseValueTemplate(s)
+		if err != nil {
+			return nil, &ValidationError{Err: err}
+		}
+		u.templates = append(u.templates, t)
+	}
+
+	if u.redactor == nil {
+		r, err := NewRedactor(RedactConfig{})
+		if err != nil {
+			return nil, err
+		}
+		u.redactor = r
+	}
+
+	return u, nil
+}
+
+// renderTemplates adds the values of the templates, computed from the current
+// values of rel.
+func (u *update) renderTemplates(rel *release.Release) error {
+	if len(u.templates) == 0 {
+		return nil
+	}
+
+	current, err := chartutil.CoalesceValues(rel.Chart, rel.Config)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range u.templates {
+		v, err := t.render(current)
+		if err != nil {
+			return &ValidationError{Err: err}
+		}
+		SetValue(u.values, t.key, v)
+	}
+
+	return nil
+}
+
+func (u *update) checkPreconditions(rel *release.Release) error {
+	if len(u.opts.Preconditions) == 0 {
+		return nil
+	}
+
+	current, err := chartutil.CoalesceValues(rel.Chart, rel.Config)
+	if err != nil {
+		return err
+	}
+
+	if err := checkPreconditions(current, u.opts.Preconditions, u.redactor); err != nil {
+		return &ConflictError{Release: rel.Name, Err: err}
+	}
+
+	return nil
+}
+
+// prepare computes the new config of rel and its changes.
+func (u *update) prepare(rel *release.Release, res *Result) error {
+	config, err := u.newConfig(rel)
+	if err != nil {
+		if _, ok := err.(*TestFailedError); ok {
+			return &ConflictError{Release: rel.Name, Err: err}
+		}
+		return &ValidationError{Err: err}
+	}
+	res.Config = config
+
+	oldVals, err := chartutil.ReadValues([]byte(rel.GetConfig().GetRaw()))
+	if err != nil {
+		return err
+	}
+	newVals, err := chartutil.ReadValues(config)
+	if err != nil {
+		return err
+	}
+	res.Changes = u.redactor.Changes(DiffValues(oldVals, newVals))
+
+	return nil
+}
+
+// newConfig returns the complete user-supplied config the release will have
+// after the update.
+func (u *update) newConfig(rel *release.Release) ([]byte, error) {
+	if u.opts.ResetValues {
+		return patchConfig("", nil, u.values)
+	}
+
+	return patchConfig(rel.GetConfig().GetRaw(), u.opts.Patches, u.values)
+}
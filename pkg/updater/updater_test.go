@@ -0,0 +1,188 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	rls "k8s.io/helm/pkg/proto/hapi/services"
+)
+
+// fakeBackend holds a single release and records the requests it gets.
+type fakeBackend struct {
+	current  *release.Release
+	requests []Request
+}
+
+func newFakeBackend(config string) *fakeBackend {
+	return &fakeBackend{current: &release.Release{
+		Name:      "web",
+		Namespace: "prod",
+		Version:   3,
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "web"}},
+		Config:    &chart.Config{Raw: config},
+		Info:      &release.Info{Status: &release.Status{Code: release.Status_DEPLOYED}},
+	}}
+}
+
+func (b *fakeBackend) Release(name string) (*release.Release, error) {
+	return b.current, nil
+}
+
+func (b *fakeBackend) ListReleases() ([]*release.Release, error) {
+	return []*release.Release{b.current}, nil
+}
+
+func (b *fakeBackend) History(name string, max int32) ([]*release.Release, error) {
+	return []*release.Release{b.current}, nil
+}
+
+func (b *fakeBackend) Update(current *release.Release, req Request) (*release.Release, error) {
+	b.requests = append(b.requests, req)
+	return &release.Release{
+		Name:      current.Name,
+		Namespace: current.Namespace,
+		Version:   current.Version + 1,
+		Config:    &chart.Config{Raw: string(req.Values)},
+		Info:      &release.Info{Status: &release.Status{Code: release.Status_DEPLOYED}},
+	}, nil
+}
+
+func TestUpdateBackendRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Options
+		values string
+		reuse  bool
+	}{
+		{
+			name:   "reuse values",
+			opts:   Options{Set: []string{"replicas=2"}},
+			values: "replicas: 2\n",
+			reuse:  true,
+		},
+		{
+			name:   "reset values",
+			opts:   Options{Set: []string{"replicas=2"}, ResetValues: true},
+			values: "replicas: 2\n",
+		},
+		{
+			// The patched config is complete, so it replaces the current one.
+			name:   "patches",
+			opts:   Options{Set: []string{"replicas=2"}, Patches: []Patch{mustJSONPatch(t, `[{"op": "remove", "path": "/logLevel"}]`)}},
+			values: "image:\n  tag: v1\nreplicas: 2\n",
+		},
+	}
+	for _, tt := range tests {
+		backend := newFakeBackend("replicas: 1\nlogLevel: debug\nimage:\n  tag: v1\n")
+		opts := tt.opts
+		opts.Release = "web"
+
+		res, err := UpdateBackend(context.Background(), backend, opts)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if len(backend.requests) != 1 {
+			t.Errorf("%s: got %d requests, want 1", tt.name, len(backend.requests))
+			continue
+		}
+		req := backend.requests[0]
+		if string(req.Values) != tt.values || req.ReuseValues != tt.reuse {
+			t.Errorf("%s: got values %q with ReuseValues %v, want %q with %v", tt.name, req.Values, req.ReuseValues, tt.values, tt.reuse)
+		}
+		if res.PreviousRevision != 3 || res.Revision != 4 || res.Status != release.Status_DEPLOYED {
+			t.Errorf("%s: got revisions %d to %d with status %s", tt.name, res.PreviousRevision, res.Revision, res.Status)
+		}
+	}
+}
+
+func TestUpdateBackendDryRun(t *testing.T) {
+	backend := newFakeBackend("replicas: 1\n")
+
+	res, err := UpdateBackend(context.Background(), backend, Options{Release: "web", Set: []string{"replicas=2"}, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.requests) != 1 || !backend.requests[0].DryRun {
+		t.Errorf("got requests %+v, want a single dry run", backend.requests)
+	}
+	if !res.DryRun || len(res.Changes) != 1 {
+		t.Errorf("got a result with DryRun %v and changes %v", res.DryRun, res.Changes)
+	}
+}
+
+func TestUpdateBackendIfRevision(t *testing.T) {
+	backend := newFakeBackend("replicas: 1\n")
+
+	_, err := UpdateBackend(context.Background(), backend, Options{Release: "web", Set: []string{"replicas=2"}, IfRevision: 2})
+	conflict, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("got error %v, want a conflict", err)
+	}
+	rev, ok := conflict.Err.(*RevisionError)
+	if !ok {
+		t.Fatalf("got cause %v, want a revision error", conflict.Err)
+	}
+	if rev.Current != 3 || rev.Expected != 2 {
+		t.Errorf("got revision %d, expected %d", rev.Current, rev.Expected)
+	}
+	if len(backend.requests) != 0 {
+		t.Errorf("release was updated at the wrong revision")
+	}
+
+	if _, err := UpdateBackend(context.Background(), backend, Options{Release: "web", Set: []string{"replicas=2"}, IfRevision: 3}); err != nil {
+		t.Errorf("update at the expected revision failed: %s", err)
+	}
+}
+
+func TestUpdatePreconditionConflict(t *testing.T) {
+	backend := newFakeBackend("image:\n  tag: v1\n")
+
+	_, err := UpdateBackend(context.Background(), backend, Options{
+		Release:       "web",
+		Set:           []string{"image.tag=v3"},
+		Preconditions: []Precondition{{Path: "image.tag", Value: "v2"}},
+	})
+	conflict, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("got error %v, want a conflict", err)
+	}
+	if _, ok := conflict.Err.(*PreconditionError); !ok {
+		t.Errorf("got cause %v, want a precondition error", conflict.Err)
+	}
+	if len(backend.requests) != 0 {
+		t.Error("release was updated although a precondition does not hold")
+	}
+}
+
+// failingClient is a Tiller client which refuses updates, quoting the values
+// as Tiller does when they do not render.
+type failingClient struct {
+	*helm.FakeClient
+}
+
+func (c failingClient) UpdateReleaseFromChart(name string, ch *chart.Chart, opts ...helm.UpdateOption) (*rls.UpdateReleaseResponse, error) {
+	return nil, errors.New(`render error: password "hunter22" is too short`)
+}
+
+func TestUpdateBackendTillerError(t *testing.T) {
+	current := newFakeBackend("password: hunter2\n").current
+	backend := &TillerBackend{Client: failingClient{&helm.FakeClient{Rels: []*release.Release{current}}}}
+
+	res, err := UpdateBackend(context.Background(), backend, Options{Release: "web", Set: []string{"password=hunter22"}})
+	tillerErr, ok := err.(*TillerError)
+	if !ok {
+		t.Fatalf("got error %v, want a Tiller error", err)
+	}
+	if tillerErr.Op != "UpdateRelease" || strings.Contains(tillerErr.Error(), "hunter22") {
+		t.Errorf("got error %q from %s, want a redacted one from UpdateRelease", tillerErr, tillerErr.Op)
+	}
+	if res == nil || res.PreviousRevision != 3 {
+		t.Errorf("got result %+v, want one describing the release", res)
+	}
+}
@@ -0,0 +1,86 @@
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LookupValue returns the value at a dot-separated path in vals.
+func LookupValue(vals map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = vals
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return cur, true
+}
+
+// FormatValue renders a config value the way it would be written with --set.
+// Tables and lists are rendered as JSON.
+func FormatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+
+	return fmt.Sprint(v)
+}
+
+// SetValue sets the value at a dot-separated path in vals, creating the
+// tables on the way.
+func SetValue(vals map[string]interface{}, path string, v interface{}) {
+	keys := strings.Split(path, ".")
+	m := vals
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[k] = next
+		}
+		m = next
+	}
+	m[keys[len(keys)-1]] = v
+}
+
+// ParseValue converts booleans and integers the way the --set parser does.
+// Anything else is kept as a string.
+func ParseValue(s string) interface{} {
+	if strings.EqualFold(s, "true") {
+		return true
+	}
+	if strings.EqualFold(s, "false") {
+		return false
+	}
+	if len(s) != 0 && s[0] != '0' {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	}
+	return s
+}
+
+// FlattenValues returns the leaves of vals by their dot-separated paths.
+// Lists and empty tables are leaves.
+func FlattenValues(vals map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	flattenValues("", vals, out)
+	return out
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/spf13/cobra"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+// plan is a reviewable config change of a single release. Applying it submits
+// NewConfig as the complete user-supplied config of the release. The diffs are
+// redacted, but the configs are not, so plan files have to be kept private.
+type plan struct {
+	Release      string           `json:"release"`
+	Namespace    string           `json:"namespace"`
+	BaseRevision int32            `json:"baseRevision"`
+	Author       string           `json:"author"`
+	CreatedAt    time.Time        `json:"createdAt"`
+	OldConfig    string           `json:"oldConfig"`
+	NewConfig    string           `json:"newConfig"`
+	ValuesDiff   []updater.Change `json:"valuesDiff"`
+	ManifestDiff string           `json:"manifestDiff"`
+
+	// AuthorSignature proves that Author made the plan, so that the author
+	// cannot be passed off as somebody else to approve their own change.
+	AuthorSignature *planSignature  `json:"authorSignature,omitempty"`
+	Signatures      []planSignature `json:"signatures,omitempty"`
+}
+
+func newPlanCmd() *cobra.Command {
+	var (
+		flags   updateFlags
+		out     string
+		keyFile string
+		author  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "plan [flags] RELEASE",
+		Short: "save a config change to a plan file without applying it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if keyFile == "" {
+				return errors.New("--key is required: plans are signed by their author")
+			}
+			if out == "" {
+				return errors.New("--out is required: plans hold the unredacted config")
+			}
+			key, err := readPrivateKey(keyFile)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := readConfig()
+			if err != nil {
+				return err
+			}
+
+			backend, err := newBackend()
+			if err != nil {
+				return err
+			}
+			defer backend.Close()
+
+			update, err := flags.command(cfg, backend, args[0])
+			if err != nil {
+				return err
+			}
+
+			p, err := update.plan(author)
+			if err != nil {
+				return err
+			}
+			if err := p.signAsAuthor(key); err != nil {
+				return err
+			}
+
+			if err := writePlan(out, p); err != nil {
+				return err
+			}
+
+			fmt.Printf("Plan for %s at revision %d saved to %s\n", p.Release, p.BaseRevision, out)
+			fmt.Print(updater.FormatChanges(p.ValuesDiff))
+			return nil
+		},
+	}
+
+	flags.register(cmd.Flags())
+	cmd.Flags().StringVar(&out, "out", "", "file to write the plan to (required)")
+	cmd.Flags().StringVar(&keyFile, "key", "", "PEM encoded ed25519 private key of the author to sign the plan with")
+	cmd.Flags().StringVar(&author, "author", currentUser(), "name of the author, matching their key file in the trusted keys directory")
+
+	return cmd
+}
+
+func newSignPlanCmd() *cobra.Command {
+	var (
+		keyFile string
+		signer  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "sign-plan [flags] PLAN_FILE",
+		Short: "approve a plan by adding an ed25519 signature to it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if keyFile == "" {
+				return errors.New("--key is required")
+			}
+
+			key, err := readPrivateKey(keyFile)
+			if err != nil {
+				return err
+			}
+
+			p, err := readPlan(args[0])
+			if err != nil {
+				return err
+			}
+			if signer == p.Author {
+				return fmt.Errorf("%s is the author of the plan and cannot approve it", signer)
+			}
+
+			if err := p.sign(signer, key); err != nil {
+				return err
+			}
+
+			if err := writePlan(args[0], p); err != nil {
+				return err
+			}
+
+			fmt.Printf("Plan for %s signed by %s\n", p.Release, strings.Join(signerNames(p), ", "))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&keyFile, "key", "", "PEM encoded ed25519 private key to sign with")
+	cmd.Flags().StringVar(&signer, "signer", currentUser(), "name of the signer, matching their key file in the trusted keys directory")
+
+	return cmd
+}
+
+func newApplyPlanCmd() *cobra.Command {
+	var trustedKeys string
+
+	cmd := &cobra.Command{
+		Use:   "apply-plan [flags] PLAN_FILE",
+		Short: "apply a config change saved with the plan command",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := readConfig()
+			if err != nil {
+				return err
+			}
+			if trustedKeys != "" {
+				cfg.Approvals.TrustedKeysDir = trustedKeys
+			}
+
+			p, err := readPlan(args[0])
+			if err != nil {
+				return err
+			}
+
+			backend, err := newBackend()
+			if err != nil {
+				return err
+			}
+			defer backend.Close()
+
+			return applyPlan(cfg, backend, p, args[0])
+		},
+	}
+
+	cmd.Flags().StringVar(&trustedKeys, "trusted-keys", "", "directory with public keys of trusted plan signers (overrides the config file)")
+
+	return cmd
+}
+
+func readPlan(filename string) (*plan, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var p plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	if p.Release == "" {
+		return nil, fmt.Errorf("%s: plan has no release", filename)
+	}
+
+	return &p, nil
+}
+
+// writePlan writes p to filename. Only its owner can read it, since the
+// configs in a plan are not redacted.
+func writePlan(filename string, p *plan) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, append(data, '\n'), 0600)
+}
+
+// plan computes the config change without applying it. The new manifest is
+// rendered with a dry-run update.
+func (cmd *updateConfigCommand) plan(author string) (*plan, error) {
+	opts := cmd.opts
+	opts.DryRun = true
+
+	res, err := updater.UpdateBackend(context.Background(), cmd.backend, opts)
+	if res != nil {
+		printWarnings(res.Warnings)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := opts.Redactor
+	return &plan{
+		Release:      res.Release,
+		Namespace:    res.Namespace,
+		BaseRevision: res.PreviousRevision,
+		Author:       author,
+		CreatedAt:    time.Now().UTC(),
+		OldConfig:    res.Previous.GetConfig().GetRaw(),
+		NewConfig:    string(res.Config),
+		ValuesDiff:   res.Changes,
+		ManifestDiff: updater.UnifiedDiff("current", "planned", r.Manifest(res.Previous.Manifest), r.Manifest(res.Updated.GetManifest())),
+	}, nil
+}
+
+// applyPlan submits the new config of p, read from filename, and records the
+// attempt like any other update.
+func applyPlan(cfg *config, backend releaseBackend, p *plan, filename string) error {
+	r, err := cfg.redactor()
+	if err != nil {
+		return err
+	}
+	n, err := cfg.notifier()
+	if err != nil {
+		return err
+	}
+
+	update := &updateConfigCommand{
+		backend:  backend,
+		opts:     updater.Options{Release: p.Release, Redactor: r},
+		journal:  cfg.journal(),
+		notifier: n,
+		action:   "apply-plan",
+		source:   filename,
+	}
+
+	start := time.Now()
+	res, err := submitPlan(backend, p, cfg.Approvals)
+	if err != nil {
+		// Tiller may quote the config in its errors.
+		old, _ := chartutil.ReadValues([]byte(p.OldConfig))
+		config, _ := chartutil.ReadValues([]byte(p.NewConfig))
+		err = r.Error(err, old, config)
+	}
+	update.record(res, err, time.Since(start))
+
+	return err
+}
+
+// submitPlan submits the new config of p, provided the release has not been
+// changed since the plan was made and the plan is approved.
+func submitPlan(backend releaseBackend, p *plan, approvals approvalConfig) (*updater.Result, error) {
+	current, err := backend.Release(p.Release)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &updater.Result{
+		Release:          current.Name,
+		Namespace:        current.Namespace,
+		PreviousRevision: current.Version,
+		Config:           []byte(p.NewConfig),
+		Changes:          p.ValuesDiff,
+		Previous:         current,
+	}
+
+	if v := current.Version; v != p.BaseRevision {
+		return res, &updater.ConflictError{
+			Release: p.Release,
+			Err:     fmt.Errorf("release %s is at revision %d, but the plan was made for revision %d", p.Release, v, p.BaseRevision),
+		}
+	}
+
+	// Approval rules are matched against the namespace reported by the
+	// backend, not the one written in the plan.
+	ns := current.Namespace
+	if p.Namespace != ns {
+		return res, fmt.Errorf("release %s is in namespace %q, but the plan was made for namespace %q", p.Release, ns, p.Namespace)
+	}
+	if err := verifyApprovals(approvals, p, ns); err != nil {
+		return res, err
+	}
+
+	updated, err := backend.Update(current, updater.Request{Values: []byte(p.NewConfig)})
+	if err != nil {
+		return res, err
+	}
+
+	res.Updated = updated
+	res.Revision = updated.GetVersion()
+	res.Status = updated.GetInfo().GetStatus().GetCode()
+
+	return res, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritePlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "web.plan")
+	if err := writePlan(filename, &plan{Release: "web", BaseRevision: 3, NewConfig: "password: hunter22\n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Errorf("plan was written with mode %o, want 600", mode)
+	}
+
+	p, err := readPlan(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Release != "web" || p.BaseRevision != 3 || p.NewConfig != "password: hunter22\n" {
+		t.Errorf("read back plan %+v", p)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/gobwas/glob"
+	"github.com/spf13/cobra"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+// promoteConfig lists keys which are never promoted between releases of a
+// chart, such as host names which differ between environments.
+type promoteConfig struct {
+	// Exclude maps chart name patterns to key patterns.
+	Exclude map[string][]string `json:"exclude"`
+}
+
+// excludes returns the key patterns excluded for a chart.
+func (c promoteConfig) excludes(chart string) ([]string, error) {
+	var patterns []string
+	for p, keys := range c.Exclude {
+		ok, err := globMatch(p, chart)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			patterns = append(patterns, keys...)
+		}
+	}
+	return patterns, nil
+}
+
+func newPromoteCmd() *cobra.Command {
+	var (
+		flags             updateFlags
+		apply             applyFlags
+		only              []string
+		exclude           []string
+		sourceTillerHost  string
+		sourceKubeContext string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "promote [flags] SRC_RELEASE DST_RELEASE",
+		Short: "copy config values from one release to another, e.g. from staging to production",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			srcName, dstName := args[0], args[1]
+
+			cfg, err := readConfig()
+			if err != nil {
+				return err
+			}
+
+			backend, err := newBackend()
+			if err != nil {
+				return err
+			}
+			defer backend.Close()
+
+			if err := apply.check(backend); err != nil {
+				return err
+			}
+
+			source := releaseBackend(backend)
+			if sourceTillerHost != "" || sourceKubeContext != "" {
+				if backendName != backendTiller && backendName != "" {
+					return fmt.Errorf("--source-tiller-host and --source-kube-context are not supported by the %s backend", backendName)
+				}
+				b, err := connectTiller(sourceTillerHost, sourceKubeContext)
+				if err != nil {
+					return fmt.Errorf("connecting to the source Tiller: %s", err)
+				}
+				defer b.Close()
+				source = b
+			}
+
+			update, err := promoteCommand(cfg, &flags, source, backend, srcName, dstName, only, exclude)
+			if err != nil {
+				return err
+			}
+
+			progress := io.Writer(os.Stdout)
+			if apply.output != outputText {
+				progress = os.Stderr
+			}
+
+			preview := update.opts
+			preview.DryRun = true
+			preview.CheckImages = false
+			res, err := updater.UpdateBackend(context.Background(), backend, preview)
+			if err != nil {
+				return err
+			}
+			if len(res.Changes) == 0 {
+				fmt.Fprintf(progress, "%s already has the values of %s\n", dstName, srcName)
+				return nil
+			}
+			fmt.Fprintf(progress, "Promoting from %s to %s:\n%s\n", srcName, dstName, updater.FormatChanges(res.Changes))
+
+			return apply.apply(backend, update)
+		},
+	}
+
+	flags.register(cmd.Flags())
+	apply.register(cmd.Flags())
+	cmd.Flags().StringArrayVar(&only, "only", []string{}, "only promote keys matching the glob, with their subtrees (can specify multiple)")
+	cmd.Flags().StringArrayVar(&exclude, "exclude", []string{}, "do not promote keys matching the glob, with their subtrees (can specify multiple)")
+	cmd.Flags().StringVar(&sourceTillerHost, "source-tiller-host", "", "address of the Tiller of the source release, if it is not the one of the destination")
+	cmd.Flags().StringVar(&sourceKubeContext, "source-kube-context", "", "kubeconfig context of the cluster running the Tiller of the source release")
+
+	return cmd
+}
+
+// promoteCommand returns the update copying the selected values of srcName in
+// source to dstName in backend. Values given on the command line override the
+// promoted ones.
+func promoteCommand(cfg *config, flags *updateFlags, source, backend releaseBackend, srcName, dstName string, only, exclude []string) (*updateConfigCommand, error) {
+	src, err := source.Release(srcName)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := backend.Release(dstName)
+	if err != nil {
+		return nil, err
+	}
+
+	chartExcludes, err := cfg.Promote.excludes(dst.GetChart().GetMetadata().GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	srcVals, err := chartutil.ReadValues([]byte(src.GetConfig().GetRaw()))
+	if err != nil {
+		return nil, fmt.Errorf("config of %s: %s", srcName, err)
+	}
+	selected, err := selectValues(srcVals, only, append(exclude, chartExcludes...))
+	if err != nil {
+		return nil, err
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no values of %s are selected for promotion", srcName)
+	}
+
+	update, err := flags.command(cfg, backend, dstName)
+	if err != nil {
+		return nil, err
+	}
+	update.opts.Values, _ = updater.ApplyMergePatch(selected, update.opts.Values).(map[string]interface{})
+	// The preview is only worth something if the update applies to the
+	// revision it was computed from.
+	update.opts.IfRevision = dst.Version
+
+	return update, nil
+}
+
+// selectValues returns the subtrees of vals whose keys match one of the only
+// patterns, or all of them if there are none, minus the subtrees matching an
+// exclude pattern.
+func selectValues(vals map[string]interface{}, only, exclude []string) (map[string]interface{}, error) {
+	compile := func(patterns []string) ([]glob.Glob, error) {
+		var gs []glob.Glob
+		for _, p := range patterns {
+			g, err := glob.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %s", p, err)
+			}
+			gs = append(gs, g)
+		}
+		return gs, nil
+	}
+
+	onlyGlobs, err := compile(only)
+	if err != nil {
+		return nil, err
+	}
+	excludeGlobs, err := compile(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return selectSubtrees("", vals, len(onlyGlobs) == 0, onlyGlobs, excludeGlobs), nil
+}
+
+func selectSubtrees(prefix string, vals map[string]interface{}, selected bool, only, exclude []glob.Glob) map[string]interface{} {
+	matches := func(gs []glob.Glob, key string) bool {
+		for _, g := range gs {
+			if g.Match(key) {
+				return true
+			}
+		}
+		return false
+	}
+
+	out := make(map[string]interface{})
+	for k, v := range vals {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if matches(exclude, key) {
+			continue
+		}
+		sel := selected || matches(only, key)
+
+		if m, ok := v.(map[string]interface{}); ok {
+			sub := selectSubtrees(key, m, sel, only, exclude)
+			if len(sub) > 0 || (sel && len(m) == 0) {
+				out[k] = sub
+			}
+			continue
+		}
+		if sel {
+			out[k] = updater.DeepCopy(v)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+func TestSelectValues(t *testing.T) {
+	vals, err := chartutil.ReadValues([]byte(`
+image:
+  repository: web
+  tag: v2
+db:
+  host: db.staging
+  password: hunter22
+  pool:
+    size: 10
+features: {}
+replicas: 3
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		only, exclude []string
+		want          string
+	}{
+		{"everything", nil, nil, `{"db":{"host":"db.staging","password":"hunter22","pool":{"size":10}},"features":{},"image":{"repository":"web","tag":"v2"},"replicas":3}`},
+		{"subtree", []string{"image"}, nil, `{"image":{"repository":"web","tag":"v2"}}`},
+		{"nested glob", []string{"*.tag", "db.pool.*"}, nil, `{"db":{"pool":{"size":10}},"image":{"tag":"v2"}}`},
+		{"exclude inside only", []string{"db"}, []string{"db.password", "db.host"}, `{"db":{"pool":{"size":10}}}`},
+		{"exclude a subtree", nil, []string{"db", "image.*"}, `{"features":{},"replicas":3}`},
+		{"empty table", []string{"features"}, nil, `{"features":{}}`},
+		{"nothing matches", []string{"missing"}, nil, `{}`},
+		{"everything excluded", []string{"image"}, []string{"image"}, `{}`},
+	}
+	for _, tt := range tests {
+		got, err := selectValues(vals, tt.only, tt.exclude)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if s := updater.FormatValue(got); s != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, s, tt.want)
+		}
+	}
+
+	if _, err := selectValues(vals, []string{"[image"}, nil); err == nil || !strings.Contains(err.Error(), `invalid pattern "[image"`) {
+		t.Errorf("got error %v for an invalid pattern", err)
+	}
+}
+
+func TestPromoteCommand(t *testing.T) {
+	src := newFakeBackend(fakeRelease("web-staging", "staging", 4, "", "image:\n  tag: v2\nhost: web.staging\nreplicas: 1\n"))
+	dst := newFakeBackend(fakeRelease("web", "prod", 7, "", "image:\n  tag: v1\nhost: web.prod\nreplicas: 5\n"))
+	cfg := &config{
+		Journal: journalConfig{Disabled: true},
+		Promote: promoteConfig{Exclude: map[string][]string{"app": {"host"}}},
+	}
+	flags := &updateFlags{values: []string{"replicas=6"}, skipImageCheck: true}
+
+	update, err := promoteCommand(cfg, flags, src, dst, "web-staging", "web", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// host is excluded for the chart, and replicas are overridden by --set.
+	if s := updater.FormatValue(update.opts.Values); s != `{"image":{"tag":"v2"},"replicas":1}` || update.opts.Set[0] != "replicas=6" {
+		t.Errorf("got values %s and set %q", s, update.opts.Set)
+	}
+	if update.opts.IfRevision != 7 {
+		t.Errorf("got IfRevision %d, want 7", update.opts.IfRevision)
+	}
+
+	// The destination changes between the preview and the update.
+	dst.releases["web"] = append(dst.releases["web"], fakeRelease("web", "prod", 8, "", "replicas: 5\n"))
+	if _, err := update.run(); !updater.IsConflict(err) {
+		t.Fatalf("got error %v, want a conflict", err)
+	}
+	if n := len(dst.releases["web"]); n != 2 {
+		t.Errorf("got %d revisions, want 2", n)
+	}
+
+	if _, err := promoteCommand(cfg, &updateFlags{}, src, dst, "web-staging", "web", []string{"host"}, nil); err == nil || !strings.Contains(err.Error(), "no values of web-staging") {
+		t.Errorf("got error %v for an empty selection", err)
+	}
+}
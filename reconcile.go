@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/spf13/cobra"
+)
+
+// reconciler keeps the releases of a directory at their desired values. Each
+// release is reconciled by at most one goroutine at a time.
+type reconciler struct {
+	dir            string
+	coalesced      bool
+	wait           bool
+	timeout        int64
+	skipImageCheck bool
+	backoff        time.Duration
+	maxBackoff     time.Duration
+
+	cfg      *config
+	redactor *updater.Redactor
+	log      *log.Logger
+	// connect opens the backend of a reconcile.
+	connect func() (releaseBackend, error)
+
+	mu       sync.Mutex
+	releases map[string]*reconcileState
+	running  sync.WaitGroup
+}
+
+// reconcileState is the state of a single release.
+type reconcileState struct {
+	file    string
+	modTime time.Time
+	size    int64
+
+	running  bool
+	pending  bool
+	failures int
+	retryAt  time.Time
+	outcome  string
+}
+
+func newReconcileCmd() *cobra.Command {
+	var (
+		r            reconciler
+		interval     time.Duration
+		pollInterval time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "reconcile --dir DIR [flags]",
+		Short: "keep releases at the desired values in a directory, until terminated",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if r.dir == "" {
+				return errors.New("--dir is required")
+			}
+			if interval <= 0 || pollInterval <= 0 || r.backoff <= 0 || r.maxBackoff < r.backoff {
+				return errors.New("--interval, --poll-interval and --backoff must be positive and --max-backoff at least --backoff")
+			}
+
+			cfg, err := readConfig()
+			if err != nil {
+				return err
+			}
+			if r.redactor, err = cfg.redactor(); err != nil {
+				return err
+			}
+			r.cfg = cfg
+			r.log = log.New(os.Stdout, "", log.LstdFlags)
+			r.connect = newBackend
+			r.releases = make(map[string]*reconcileState)
+
+			stop := make(chan os.Signal, 1)
+			signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+			defer signal.Stop(stop)
+
+			return r.run(interval, pollInterval, stop)
+		},
+	}
+
+	cmd.Flags().StringVar(&r.dir, "dir", "", "directory with the desired values of the releases, one RELEASE.yaml file each")
+	cmd.Flags().DurationVar(&interval, "interval", time.Minute, "how often every release is checked for drift")
+	cmd.Flags().DurationVar(&pollInterval, "poll-interval", 5*time.Second, "how often the directory is checked for changed files, which are reconciled right away, in case watching it misses a change or is not supported")
+	cmd.Flags().DurationVar(&r.backoff, "backoff", 10*time.Second, "time to wait before retrying a release that failed, doubled with every failure in a row")
+	cmd.Flags().DurationVar(&r.maxBackoff, "max-backoff", 10*time.Minute, "longest time to wait before retrying a release that failed")
+	cmd.Flags().BoolVar(&r.coalesced, "coalesced", false, "compare the values including the defaults of the chart, so that values set to their default are no drift")
+	cmd.Flags().BoolVar(&r.wait, "wait", false, "wait until all resources of an updated release are ready, for at most --timeout seconds")
+	cmd.Flags().Int64Var(&r.timeout, "timeout", 300, "time in seconds to wait for any individual Kubernetes operation")
+	cmd.Flags().BoolVar(&r.skipImageCheck, "skip-image-check", false, "do not check that the images of the new manifest exist in their registries")
+
+	return cmd
+}
+
+// run reconciles all releases every interval, and the ones whose file changed
+// as soon as the change is noticed, until stop receives a signal. Changes are
+// watched for where the system supports it, and polled for every
+// pollInterval in any case. Reconciles in progress are completed before it
+// returns.
+func (r *reconciler) run(interval, pollInterval time.Duration, stop <-chan os.Signal) error {
+	r.log.Printf("reconciling releases in %s every %s", r.dir, interval)
+
+	changes, unwatch, err := watchDir(r.dir)
+	if err != nil {
+		r.log.Printf("watching %s: %s, polling every %s instead", r.dir, err, pollInterval)
+	} else {
+		defer unwatch()
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	var nextFull time.Time
+	for {
+		now := time.Now()
+		full := !now.Before(nextFull)
+		if full {
+			nextFull = now.Add(interval)
+		}
+		r.poll(now, full)
+
+		select {
+		case sig := <-stop:
+			r.log.Printf("received %s, waiting for running reconciles to finish", sig)
+			r.running.Wait()
+			return nil
+		case <-ticker.C:
+		case <-changes:
+		}
+	}
+}
+
+// poll starts reconciles of the releases whose file changed, which are due
+// for a retry or, if full is set, of all of them. A new registry is used for
+// every poll, so images pushed in the meantime are found.
+func (r *reconciler) poll(now time.Time, full bool) {
+	files, err := desiredFiles(r.dir)
+	if err != nil {
+		r.log.Printf("reading %s: %s", r.dir, err)
+		return
+	}
+
+	registry := updater.NewRegistry()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, s := range r.releases {
+		if _, ok := files[name]; !ok && !s.running {
+			r.log.Printf("%s: values file removed, no longer reconciled", name)
+			delete(r.releases, name)
+		}
+	}
+
+	for name, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			r.log.Printf("%s: %s", name, err)
+			continue
+		}
+
+		s, ok := r.releases[name]
+		if !ok {
+			s = &reconcileState{}
+			r.releases[name] = s
+		}
+
+		changed := !ok || s.file != file || !s.modTime.Equal(info.ModTime()) || s.size != info.Size()
+		s.file, s.modTime, s.size = file, info.ModTime(), info.Size()
+		if changed {
+			// The change may well be the fix for the failure.
+			s.retryAt = time.Time{}
+		}
+
+		due := full || changed || s.pending || (s.failures > 0 && !now.Before(s.retryAt))
+		if !due {
+			continue
+		}
+		if s.running {
+			s.pending = true
+			continue
+		}
+		if now.Before(s.retryAt) {
+			continue
+		}
+
+		s.running, s.pending = true, false
+		r.running.Add(1)
+		go r.reconcile(name, file, registry)
+	}
+}
+
+// reconcile brings a single release to its desired values and records the
+// outcome in its state.
+func (r *reconciler) reconcile(name, file string, registry *updater.Registry) {
+	defer r.running.Done()
+
+	outcome, err := r.fix(name, file, registry)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.releases[name]
+	if s == nil {
+		return
+	}
+	s.running = false
+
+	if err != nil {
+		s.failures++
+		backoff := r.backoff
+		for i := 1; i < s.failures && backoff < r.maxBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+		s.retryAt = time.Now().Add(backoff)
+		s.outcome = ""
+		r.log.Printf("%s: %s (failure %d, retrying in %s)", name, err, s.failures, backoff)
+		return
+	}
+
+	s.failures = 0
+	s.retryAt = time.Time{}
+	if outcome != s.outcome {
+		r.log.Printf("%s: %s", name, outcome)
+		s.outcome = outcome
+	}
+}
+
+// fix updates a release if it has drifted and describes the outcome.
+func (r *reconciler) fix(name, file string, registry *updater.Registry) (string, error) {
+	// A connection per reconcile, so that a broken one does not stop the
+	// reconciler.
+	backend, err := r.connect()
+	if err != nil {
+		return "", err
+	}
+	defer backend.Close()
+
+	rep, vals, err := checkDrift(backend, r.redactor, name, file, r.coalesced)
+	if err != nil {
+		return "", err
+	}
+	if !rep.drifted() {
+		return "in sync", nil
+	}
+
+	update, err := fixDriftCommand(r.cfg, backend, r.redactor, rep, vals)
+	if err != nil {
+		return "", err
+	}
+	update.opts.CheckImages = !r.skipImageCheck
+	update.opts.Registry = registry
+	update.opts.Wait = r.wait
+	update.opts.Timeout = r.timeout
+
+	res, err := update.run()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("fixed %d extra, %d missing and %d different keys at revision %d",
+		len(rep.Extra), len(rep.Missing), len(rep.Different), res.Revision), nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+)
+
+// newTestReconciler returns a reconciler of dir which connects to backend and
+// counts the reconciles.
+func newTestReconciler(t *testing.T, dir string, backend releaseBackend) (*reconciler, func() int) {
+	t.Helper()
+	redactor, err := updater.NewRedactor(updater.RedactConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu    sync.Mutex
+		count int
+	)
+	r := &reconciler{
+		dir:            dir,
+		skipImageCheck: true,
+		backoff:        time.Minute,
+		maxBackoff:     3 * time.Minute,
+		cfg:            &config{Journal: journalConfig{Disabled: true}},
+		redactor:       redactor,
+		log:            log.New(ioutil.Discard, "", 0),
+		releases:       make(map[string]*reconcileState),
+		connect: func() (releaseBackend, error) {
+			mu.Lock()
+			count++
+			mu.Unlock()
+			return backend, nil
+		},
+	}
+	return r, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return count
+	}
+}
+
+func TestReconcilerPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconcile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := newFakeBackend(
+		fakeRelease("web", "prod", 1, "", "replicas: 2\n"),
+		fakeRelease("api", "prod", 1, "", "replicas: 1\n"),
+	)
+	writeTempFile(t, dir, "web.yaml", "replicas: 3\n")
+	writeTempFile(t, dir, "api.yaml", "replicas: 1\n")
+
+	r, reconciles := newTestReconciler(t, dir, backend)
+	now := time.Now()
+
+	r.poll(now, false)
+	r.running.Wait()
+	if n := reconciles(); n != 2 {
+		t.Fatalf("got %d reconciles of new files, want 2", n)
+	}
+	if s := r.releases["web"]; !strings.HasPrefix(s.outcome, "fixed 0 extra, 0 missing and 1 different keys at revision 2") {
+		t.Errorf("unexpected outcome of web: %q", s.outcome)
+	}
+	if s := r.releases["api"]; s.outcome != "in sync" {
+		t.Errorf("unexpected outcome of api: %q", s.outcome)
+	}
+
+	// Unchanged files are left alone until the next full check.
+	r.poll(now, false)
+	r.running.Wait()
+	if n := reconciles(); n != 2 {
+		t.Errorf("got %d reconciles without changes, want 2", n)
+	}
+	r.poll(now, true)
+	r.running.Wait()
+	if n := reconciles(); n != 4 {
+		t.Errorf("got %d reconciles after a full check, want 4", n)
+	}
+	if s := r.releases["web"]; s.outcome != "in sync" {
+		t.Errorf("unexpected outcome of web after the fix: %q", s.outcome)
+	}
+
+	writeTempFile(t, dir, "web.yaml", "replicas: 40\n")
+	if err := os.Remove(filepath.Join(dir, "api.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	r.poll(now, false)
+	r.running.Wait()
+	if n := reconciles(); n != 5 {
+		t.Errorf("got %d reconciles after a change, want 5", n)
+	}
+	if _, ok := r.releases["api"]; ok {
+		t.Error("release of a removed file is still reconciled")
+	}
+}
+
+func TestReconcilerBackoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconcile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := newFakeBackend(fakeRelease("web", "prod", 1, "", "replicas: 2\n"))
+	backend.updateErr = errors.New("tiller is down")
+	writeTempFile(t, dir, "web.yaml", "replicas: 3\n")
+
+	r, reconciles := newTestReconciler(t, dir, backend)
+	now := time.Now()
+
+	wantBackoff := func(failures int, backoff time.Duration) {
+		t.Helper()
+		s := r.releases["web"]
+		if s.failures != failures || s.outcome != "" {
+			t.Fatalf("got %d failures and outcome %q, want %d failures", s.failures, s.outcome, failures)
+		}
+		if d := s.retryAt.Sub(time.Now()); d <= backoff-time.Second || d > backoff {
+			t.Errorf("retrying in %s after %d failures, want %s", d, failures, backoff)
+		}
+	}
+
+	r.poll(now, false)
+	r.running.Wait()
+	wantBackoff(1, time.Minute)
+
+	// Failed releases are not retried before their backoff, even by a full
+	// check.
+	r.poll(now, true)
+	r.running.Wait()
+	if n := reconciles(); n != 1 {
+		t.Fatalf("got %d reconciles during the backoff, want 1", n)
+	}
+
+	for i, backoff := range []time.Duration{2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		r.poll(r.releases["web"].retryAt, false)
+		r.running.Wait()
+		wantBackoff(i+2, backoff)
+	}
+
+	// A fixed file is retried right away, and success resets the failures.
+	backend.updateErr = nil
+	writeTempFile(t, dir, "web.yaml", "replicas: 30\n")
+	r.poll(now, false)
+	r.running.Wait()
+	if s := r.releases["web"]; s.failures != 0 || !s.retryAt.IsZero() || !strings.HasPrefix(s.outcome, "fixed") {
+		t.Errorf("unexpected state after a successful retry: %+v", s)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// resource is a row of the resources table Tiller renders for a release with
+// kubectl get. Columns are keyed by their header.
+type resource struct {
+	Kind    string
+	Name    string
+	Columns map[string]string
+}
+
+// parseResources reads Info.Status.Resources, which consists of sections like
+//
+//	==> v1beta1/Deployment
+//	NAME   DESIRED  CURRENT  UP-TO-DATE  AVAILABLE  AGE
+//	nginx  1        1        1           1          5d
+//
+// Values are left-aligned under their headers, so rows are split at the
+// offsets of the headers rather than at whitespace.
+func parseResources(s string) []resource {
+	var (
+		out     []resource
+		kind    string
+		headers []string
+		offsets []int
+	)
+
+	for _, line := range strings.Split(s, "\n") {
+		switch {
+		case strings.HasPrefix(line, "==> "):
+			kind = strings.TrimPrefix(line, "==> ")
+			if i := strings.LastIndex(kind, "/"); i >= 0 {
+				kind = kind[i+1:]
+			}
+			kind = strings.TrimSuffix(kind, "(related)")
+			headers = nil
+		case strings.TrimSpace(line) == "":
+			headers = nil
+		case headers == nil:
+			headers, offsets = splitHeader(line)
+		default:
+			r := resource{Kind: kind, Columns: make(map[string]string)}
+			for i, h := range headers {
+				r.Columns[h] = column(line, offsets, i)
+			}
+			r.Name = r.Columns["NAME"]
+			out = append(out, r)
+		}
+	}
+
+	return out
+}
+
+// splitHeader returns the headers of a table and their offsets. Columns are
+// padded by at least two spaces, so a single space is part of a header, as in
+// NODE SELECTOR.
+func splitHeader(line string) ([]string, []int) {
+	var (
+		headers []string
+		offsets []int
+	)
+
+	blank := func(i int) bool {
+		return line[i] == '\t' || line[i] == ' ' && (i+1 == len(line) || line[i+1] == ' ' || line[i+1] == '\t')
+	}
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && !blank(i) {
+			i++
+		}
+		headers = append(headers, line[start:i])
+		offsets = append(offsets, start)
+	}
+
+	return headers, offsets
+}
+
+func column(line string, offsets []int, i int) string {
+	start := offsets[i]
+	if start >= len(line) {
+		return ""
+	}
+	end := len(line)
+	if i+1 < len(offsets) && offsets[i+1] < end {
+		end = offsets[i+1]
+	}
+	return strings.TrimSpace(line[start:end])
+}
+
+// summary condenses the columns of a resource to its readiness, if it has any.
+func (r resource) summary() string {
+	c := r.Columns
+
+	switch {
+	case c["STATUS"] != "" && c["READY"] != "":
+		return c["READY"] + " " + c["STATUS"]
+	case c["DESIRED"] != "" && c["AVAILABLE"] != "":
+		return fmt.Sprintf("%s/%s available", c["AVAILABLE"], c["DESIRED"])
+	case c["DESIRED"] != "" && c["READY"] != "":
+		return fmt.Sprintf("%s/%s ready", c["READY"], c["DESIRED"])
+	case c["READY"] != "":
+		return c["READY"] + " ready"
+	case c["STATUS"] != "":
+		return c["STATUS"]
+	}
+
+	return "-"
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// tillerResources is a resources table as rendered by Tiller 2.14.
+const tillerResources = `==> v1/ConfigMap
+NAME        DATA  AGE
+web-config  2     5d
+
+==> v1/Pod(related)
+NAME                  READY  STATUS            RESTARTS  AGE
+web-5d8f7c9b4-2xkqz   1/1    Running           0         5d
+web-5d8f7c9b4-9lmwp   0/1    CrashLoopBackOff  12        1h
+
+==> v1/Service
+NAME  TYPE       CLUSTER-IP    EXTERNAL-IP  PORT(S)  AGE
+web   ClusterIP  10.0.171.239  <none>       80/TCP   5d
+
+==> v1beta1/Deployment
+NAME  DESIRED  CURRENT  UP-TO-DATE  AVAILABLE  AGE
+web   2        2        2           1          5d
+
+==> v1/StatefulSet
+NAME  READY  AGE
+db    1/1    5d
+
+==> v1beta1/DaemonSet
+NAME    DESIRED  CURRENT  READY  UP-TO-DATE  AVAILABLE  NODE SELECTOR  AGE
+agent   3        3        3      3           3          <none>         5d
+`
+
+func TestParseResources(t *testing.T) {
+	want := []string{
+		"ConfigMap/web-config: -",
+		"Pod/web-5d8f7c9b4-2xkqz: 1/1 Running",
+		"Pod/web-5d8f7c9b4-9lmwp: 0/1 CrashLoopBackOff",
+		"Service/web: -",
+		"Deployment/web: 1/2 available",
+		"StatefulSet/db: 1/1 ready",
+		"DaemonSet/agent: 3/3 available",
+	}
+
+	var got []string
+	for _, r := range parseResources(tillerResources) {
+		got = append(got, fmt.Sprintf("%s/%s: %s", r.Kind, r.Name, r.summary()))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got resources\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestParseResourcesColumns(t *testing.T) {
+	tests := []struct {
+		name   string
+		table  string
+		column string
+		want   string
+	}{
+		// Values are split at the offsets of the headers, so a header with
+		// a space stays one column.
+		{"header with a space", tillerResources, "NODE SELECTOR", "<none>"},
+		{"column after a header with a space", tillerResources, "AGE", "5d"},
+		{"empty trailing column", "==> v1/Pod\nNAME  READY  STATUS   AGE\nweb   0/1    Pending\n", "AGE", ""},
+	}
+	for _, tt := range tests {
+		rs := parseResources(tt.table)
+		if len(rs) == 0 {
+			t.Errorf("%s: no resources", tt.name)
+			continue
+		}
+		r := rs[len(rs)-1]
+		if got := r.Columns[tt.column]; got != tt.want {
+			t.Errorf("%s: got %s %q, want %q", tt.name, tt.column, got, tt.want)
+		}
+	}
+}
+
+func TestResourceSummary(t *testing.T) {
+	tests := []struct {
+		columns map[string]string
+		want    string
+	}{
+		{map[string]string{"READY": "1/1", "STATUS": "Running"}, "1/1 Running"},
+		{map[string]string{"DESIRED": "3", "AVAILABLE": "2", "READY": "3"}, "2/3 available"},
+		{map[string]string{"DESIRED": "3", "READY": "1"}, "1/3 ready"},
+		{map[string]string{"READY": "2/2"}, "2/2 ready"},
+		{map[string]string{"STATUS": "Bound"}, "Bound"},
+		{map[string]string{"DATA": "2"}, "-"},
+	}
+	for _, tt := range tests {
+		if got := (resource{Columns: tt.columns}).summary(); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.columns, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/ghodss/yaml"
+	"github.com/gobwas/glob"
+	"github.com/spf13/cobra"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// releaseValues are the coalesced values of a release.
+type releaseValues struct {
+	release   string
+	namespace string
+	values    map[string]interface{}
+}
+
+// readAllValues coalesces the values of every release, or of the releases in
+// --namespace, with at most concurrency releases at a time. Releases are
+// taken as listed rather than read again by name, which is ambiguous for
+// Helm 3 releases of the same name in several namespaces. Releases whose
+// values cannot be coalesced are reported on stderr and skipped.
+func readAllValues(backend releaseBackend, concurrency int) ([]releaseValues, error) {
+	if concurrency < 1 {
+		return nil, errors.New("--concurrency must be at least 1")
+	}
+
+	listed, err := backend.ListReleases()
+	if err != nil {
+		return nil, err
+	}
+
+	var rels []*release.Release
+	for _, r := range listed {
+		if namespace == "" || r.Namespace == namespace {
+			rels = append(rels, r)
+		}
+	}
+
+	var (
+		out = make([]releaseValues, len(rels))
+		ok  = make([]bool, len(rels))
+		sem = make(chan struct{}, concurrency)
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+	)
+	for i, rel := range rels {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, rel *release.Release) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			vals, err := chartutil.CoalesceValues(rel.Chart, rel.Config)
+			if err == nil {
+				out[i] = releaseValues{release: rel.Name, namespace: rel.Namespace, values: vals}
+				ok[i] = true
+				return
+			}
+
+			mu.Lock()
+			fmt.Fprintf(os.Stderr, "WARNING: skipping release %s in %s: %s\n", rel.Name, rel.Namespace, err)
+			mu.Unlock()
+		}(i, rel)
+	}
+	wg.Wait()
+
+	var res []releaseValues
+	for i := range out {
+		if ok[i] {
+			res = append(res, out[i])
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].release != res[j].release {
+			return res[i].release < res[j].release
+		}
+		return res[i].namespace < res[j].namespace
+	})
+
+	return res, nil
+}
+
+// searchMatch is a value found by search. Its field names are part of the
+// command line interface and must not change.
+type searchMatch struct {
+	Release   string `json:"release"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+}
+
+func newSearchCmd() *cobra.Command {
+	var (
+		key         string
+		value       string
+		output      string
+		concurrency int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "search [flags]",
+		Short: "find the values of all releases matching key and value patterns",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if key == "" && value == "" {
+				return errors.New("--key or --value is required")
+			}
+			if err := checkOutputFormat(output); err != nil {
+				return err
+			}
+
+			keyGlob, err := compileGlob(key)
+			if err != nil {
+				return err
+			}
+			valueGlob, err := compileGlob(value)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := readConfig()
+			if err != nil {
+				return err
+			}
+			r, err := cfg.redactor()
+			if err != nil {
+				return err
+			}
+
+			backend, err := newBackend()
+			if err != nil {
+				return err
+			}
+			defer backend.Close()
+
+			all, err := readAllValues(backend, concurrency)
+			if err != nil {
+				return err
+			}
+
+			matches := searchValues(all, r, keyGlob, valueGlob)
+			return writeTable(os.Stdout, output, matches, []string{"RELEASE", "NAMESPACE", "KEY", "VALUE"}, func(i int) []string {
+				m := matches[i]
+				return []string{m.Release, m.Namespace, m.Key, m.Value}
+			}, len(matches))
+		},
+	}
+
+	cmd.Flags().StringVar(&key, "key", "", "glob the key path has to match, e.g. image.tag or '*.enabled'")
+	cmd.Flags().StringVar(&value, "value", "", "glob the value has to match, e.g. 'v1.*'")
+	cmd.Flags().StringVarP(&output, "output", "o", outputText, "format of the result: text, json or yaml")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 8, "number of releases whose values are coalesced at the same time")
+
+	return cmd
+}
+
+// searchValues returns the values of all whose keys match keyGlob and whose
+// values match valueGlob. A nil glob matches everything.
+func searchValues(all []releaseValues, r *updater.Redactor, keyGlob, valueGlob glob.Glob) []searchMatch {
+	matches := []searchMatch{}
+	for _, rv := range all {
+		flat := updater.FlattenValues(rv.values)
+		keys := make([]string, 0, len(flat))
+		for k := range flat {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if keyGlob != nil && !keyGlob.Match(k) {
+				continue
+			}
+			// Sensitive values are matched and shown redacted, so search
+			// cannot be used to guess them.
+			v := updater.FormatValue(r.Value(k, flat[k]))
+			if valueGlob != nil && !valueGlob.Match(v) {
+				continue
+			}
+			matches = append(matches, searchMatch{Release: rv.release, Namespace: rv.namespace, Key: k, Value: v})
+		}
+	}
+	return matches
+}
+
+// matrixRow holds the values of the keys of a matrix for one release. Keys
+// which are not set are missing from Values.
+type matrixRow struct {
+	Release   string            `json:"release"`
+	Namespace string            `json:"namespace"`
+	Values    map[string]string `json:"values"`
+}
+
+func newMatrixCmd() *cobra.Command {
+	var (
+		keys        []string
+		output      string
+		concurrency int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "matrix [flags]",
+		Short: "print a table of values across all releases",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(keys) == 0 {
+				return errors.New("at least one --key is required")
+			}
+			if err := checkOutputFormat(output); err != nil {
+				return err
+			}
+
+			cfg, err := readConfig()
+			if err != nil {
+				return err
+			}
+			r, err := cfg.redactor()
+			if err != nil {
+				return err
+			}
+
+			backend, err := newBackend()
+			if err != nil {
+				return err
+			}
+			defer backend.Close()
+
+			all, err := readAllValues(backend, concurrency)
+			if err != nil {
+				return err
+			}
+
+			rows := []matrixRow{}
+			for _, rv := range all {
+				row := matrixRow{Release: rv.release, Namespace: rv.namespace, Values: make(map[string]string)}
+				for _, k := range keys {
+					if v, ok := updater.LookupValue(rv.values, k); ok {
+						row.Values[k] = updater.FormatValue(r.Value(k, v))
+					}
+				}
+				rows = append(rows, row)
+			}
+
+			header := append([]string{"RELEASE", "NAMESPACE"}, keys...)
+			return writeTable(os.Stdout, output, rows, header, func(i int) []string {
+				cells := []string{rows[i].Release, rows[i].Namespace}
+				for _, k := range keys {
+					v, ok := rows[i].Values[k]
+					if !ok {
+						v = "-"
+					}
+					cells = append(cells, v)
+				}
+				return cells
+			}, len(rows))
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&keys, "key", []string{}, "key path to show, e.g. image.tag (can specify multiple)")
+	cmd.Flags().StringVarP(&output, "output", "o", outputText, "format of the result: text, json or yaml")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 8, "number of releases whose values are coalesced at the same time")
+
+	return cmd
+}
+
+// compileGlob compiles a pattern, returning nil for an empty one.
+func compileGlob(pattern string) (glob.Glob, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	g, err := glob.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+	}
+	return g, nil
+}
+
+// writeTable writes doc as JSON or YAML, or n rows of cells as a table.
+func writeTable(w io.Writer, format string, doc interface{}, header []string, cells func(i int) []string, n int) error {
+	switch format {
+	case outputJSON:
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case outputYAML:
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	var b bytes.Buffer
+	tw := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for i := 0; i < n; i++ {
+		// Multi-line values would break the columns.
+		row := strings.Join(cells(i), "\t")
+		fmt.Fprintln(tw, strings.Replace(row, "\n", `\n`, -1))
+	}
+	tw.Flush()
+
+	_, err := b.WriteTo(w)
+	return err
+}
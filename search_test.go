@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+)
+
+func TestReadAllValuesDuplicateNames(t *testing.T) {
+	b, _, done := newTestStorageBackend(t, backendSecret, "",
+		helm3Object(t, backendSecret, true, "web", "prod", 1, "superseded", `{"image": {"tag": "v1"}}`),
+		helm3Object(t, backendSecret, true, "web", "prod", 2, "deployed", `{"image": {"tag": "v2"}}`),
+		helm3Object(t, backendSecret, true, "web", "staging", 1, "deployed", `{"image": {"tag": "v3"}}`),
+	)
+	defer done()
+
+	all, err := readAllValues(b, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, rv := range all {
+		tag, _ := updater.LookupValue(rv.values, "image.tag")
+		replicas, _ := updater.LookupValue(rv.values, "replicas")
+		got = append(got, fmt.Sprintf("%s/%s %v %v", rv.namespace, rv.release, tag, replicas))
+	}
+	if fmt.Sprint(got) != "[prod/web v2 1 staging/web v3 1]" {
+		t.Errorf("got values %q", got)
+	}
+}
+
+func TestSearchValues(t *testing.T) {
+	all := []releaseValues{
+		{release: "api", namespace: "prod", values: map[string]interface{}{
+			"image":    map[string]interface{}{"tag": "v1.2.0"},
+			"debug":    true,
+			"password": "hunter22",
+		}},
+		{release: "web", namespace: "prod", values: map[string]interface{}{
+			"image": map[string]interface{}{"tag": "v2.0.0"},
+			"debug": false,
+		}},
+	}
+	r, err := updater.NewRedactor(updater.RedactConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key, value string
+		want       string
+	}{
+		{"image.tag", "v1.*", "[api image.tag=v1.2.0]"},
+		{"image.tag", "", "[api image.tag=v1.2.0 web image.tag=v2.0.0]"},
+		{"", "true", "[api debug=true]"},
+		{"*.tag", "v3*", "[]"},
+		// Sensitive values are only matched in their redacted form.
+		{"password", "hunter*", "[]"},
+	}
+	for _, tt := range tests {
+		keyGlob, err := compileGlob(tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		valueGlob, err := compileGlob(tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, m := range searchValues(all, r, keyGlob, valueGlob) {
+			got = append(got, fmt.Sprintf("%s %s=%s", m.Release, m.Key, m.Value))
+		}
+		if s := fmt.Sprint(got); s != tt.want {
+			t.Errorf("key %q, value %q: got %s, want %s", tt.key, tt.value, s, tt.want)
+		}
+	}
+}
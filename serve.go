@@ -0,0 +1,661 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/spf13/cobra"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// serveConfig configures the API of serve.
+type serveConfig struct {
+	Tokens []apiToken `json:"tokens"`
+}
+
+// apiToken grants access to the API. Without Releases and Namespaces it
+// grants access to every release, otherwise to the releases matching either.
+type apiToken struct {
+	// Name identifies the token in the journal and in logs.
+	Name string `json:"name"`
+	// Token is the secret, or TokenEnv the environment variable holding it.
+	Token      string   `json:"token"`
+	TokenEnv   string   `json:"tokenEnv"`
+	Releases   []string `json:"releases"`
+	Namespaces []string `json:"namespaces"`
+	// ReadOnly tokens cannot apply changes, only read and dry-run them.
+	ReadOnly bool `json:"readOnly"`
+}
+
+// allows reports whether the token grants access to a release. An empty
+// namespace only matches tokens allowing the release by name.
+func (t *apiToken) allows(name, namespace string) bool {
+	if len(t.Releases) == 0 && len(t.Namespaces) == 0 {
+		return true
+	}
+	for _, p := range t.Releases {
+		if ok, _ := globMatch(p, name); ok {
+			return true
+		}
+	}
+	if namespace == "" {
+		return false
+	}
+	for _, p := range t.Namespaces {
+		if ok, _ := globMatch(p, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tokens returns the API tokens with their secrets resolved.
+func (c serveConfig) tokens() ([]apiToken, error) {
+	if len(c.Tokens) == 0 {
+		return nil, errors.New("no API tokens are configured in serve.tokens of the config file")
+	}
+
+	tokens := make([]apiToken, len(c.Tokens))
+	for i, t := range c.Tokens {
+		if t.Name == "" {
+			return nil, fmt.Errorf("serve.tokens[%d]: name is required", i)
+		}
+		if t.TokenEnv != "" {
+			t.Token = os.Getenv(t.TokenEnv)
+		}
+		if t.Token == "" {
+			return nil, fmt.Errorf("serve.tokens[%d] (%s): token is empty", i, t.Name)
+		}
+		for _, p := range append(append([]string{}, t.Releases...), t.Namespaces...) {
+			if p == "" {
+				return nil, fmt.Errorf("serve.tokens[%d] (%s): empty pattern", i, t.Name)
+			}
+			if _, err := globMatch(p, ""); err != nil {
+				return nil, fmt.Errorf("serve.tokens[%d] (%s): %s", i, t.Name, err)
+			}
+		}
+		tokens[i] = t
+	}
+
+	return tokens, nil
+}
+
+func newServeCmd() *cobra.Command {
+	var (
+		listen  string
+		tlsCert string
+		tlsKey  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "serve [flags]",
+		Short: "serve an HTTP API to read and change the config of releases",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (tlsCert == "") != (tlsKey == "") {
+				return errors.New("--tls-cert and --tls-key have to be given together")
+			}
+
+			cfg, err := readConfig()
+			if err != nil {
+				return err
+			}
+			s, err := newServer(cfg)
+			if err != nil {
+				return err
+			}
+
+			srv := &http.Server{
+				Addr:              listen,
+				Handler:           s,
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+
+			errc := make(chan error, 1)
+			go func() {
+				if tlsCert != "" {
+					errc <- srv.ListenAndServeTLS(tlsCert, tlsKey)
+				} else {
+					errc <- srv.ListenAndServe()
+				}
+			}()
+			s.log.Printf("serving the API on %s", listen)
+
+			stop := make(chan os.Signal, 1)
+			signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+			defer signal.Stop(stop)
+
+			select {
+			case err := <-errc:
+				return err
+			case sig := <-stop:
+				s.log.Printf("received %s, waiting for running requests to finish", sig)
+				return srv.Shutdown(context.Background())
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", ":8080", "address to listen on")
+	cmd.Flags().StringVar(&tlsCert, "tls-cert", "", "certificate file to serve HTTPS with")
+	cmd.Flags().StringVar(&tlsKey, "tls-key", "", "key file of --tls-cert")
+
+	return cmd
+}
+
+// server is the HTTP API of serve. Every request uses a new backend, and
+// changes of the same release are made one at a time.
+type server struct {
+	cfg        *config
+	redactor   *updater.Redactor
+	tokens     []apiToken
+	notifier   *notifier
+	metrics    *serverMetrics
+	log        *log.Logger
+	newBackend func() (releaseBackend, error)
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newServer(cfg *config) (*server, error) {
+	r, err := cfg.redactor()
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := cfg.Serve.tokens()
+	if err != nil {
+		return nil, err
+	}
+	n, err := cfg.notifier()
+	if err != nil {
+		return nil, err
+	}
+
+	return &server{
+		cfg:        cfg,
+		redactor:   r,
+		tokens:     tokens,
+		notifier:   n,
+		metrics:    newServerMetrics(),
+		log:        log.New(os.Stderr, "", log.LstdFlags),
+		newBackend: newBackend,
+		locks:      make(map[string]*sync.Mutex),
+	}, nil
+}
+
+// lock serializes changes of a release and returns the function unlocking it.
+func (s *server) lock(name string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[name]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[name] = l
+	}
+	s.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// httpError is an error with the status code it is answered with.
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func errorf(code int, format string, args ...interface{}) error {
+	return &httpError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// statusCode maps an error to the status code of the response.
+func statusCode(err error) int {
+	switch e := err.(type) {
+	case *httpError:
+		return e.code
+	case *updater.ValidationError:
+		return http.StatusBadRequest
+	case *updater.ConflictError:
+		if _, ok := e.Err.(*updater.RevisionError); ok {
+			return http.StatusPreconditionFailed
+		}
+		return http.StatusConflict
+	case *updater.TillerError:
+		if strings.Contains(e.Error(), "not found") {
+			return http.StatusNotFound
+		}
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// statusRecorder remembers the status code of a response for the metrics.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	s.metrics.start()
+
+	rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+	handler, token, err := s.route(rec, r)
+	if err != nil {
+		writeJSON(rec, statusCode(err), map[string]string{"error": err.Error()})
+	}
+
+	d := time.Since(start)
+	s.metrics.request(handler, rec.code, d)
+	if handler != "metrics" && handler != "healthz" {
+		s.log.Printf("%s %s %d %s token=%s", r.Method, r.URL.Path, rec.code, d, token)
+	}
+}
+
+// releaseHandler answers a request about a release the token has access to.
+type releaseHandler func(w http.ResponseWriter, r *http.Request, backend releaseBackend, rel *release.Release, token *apiToken) error
+
+// route answers a request and returns the name of its handler for the
+// metrics and the name of the token it was made with.
+func (s *server) route(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	switch r.URL.Path {
+	case "/healthz":
+		_, err := w.Write([]byte("ok\n"))
+		return "healthz", "", err
+	case "/metrics":
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		return "metrics", "", s.metrics.write(w)
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "v1" || parts[1] != "releases" || parts[2] == "" {
+		return "unknown", "", errorf(http.StatusNotFound, "not found: %s", r.URL.Path)
+	}
+	name, action := parts[2], parts[3]
+
+	var (
+		method string
+		h      releaseHandler
+	)
+	switch action {
+	case "config":
+		method, h = http.MethodGet, s.getConfig
+	case "history":
+		method, h = http.MethodGet, s.getHistory
+	case "diff":
+		method, h = http.MethodGet, s.getDiff
+	case "dry-run":
+		method, h = http.MethodPost, s.dryRun
+	case "apply":
+		method, h = http.MethodPost, s.apply
+	default:
+		return "unknown", "", errorf(http.StatusNotFound, "not found: %s", r.URL.Path)
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		return action, "", errorf(http.StatusMethodNotAllowed, "%s requires %s", action, method)
+	}
+
+	token := s.authenticate(r)
+	if token == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="helm-update-config"`)
+		return action, "", errorf(http.StatusUnauthorized, "a valid bearer token is required")
+	}
+	if action == "apply" && token.ReadOnly {
+		return action, token.Name, errorf(http.StatusForbidden, "token %s is read-only", token.Name)
+	}
+
+	backend, err := s.newBackend()
+	if err != nil {
+		return action, token.Name, err
+	}
+	defer backend.Close()
+
+	rel, err := backend.Release(name)
+	// Tokens without access to a release do not learn whether it exists.
+	if !token.allows(name, "") && (err != nil || !token.allows(rel.Name, rel.Namespace)) {
+		return action, token.Name, errorf(http.StatusForbidden, "token %s has no access to release %s", token.Name, name)
+	}
+	if err != nil {
+		return action, token.Name, err
+	}
+
+	return action, token.Name, h(w, r, backend, rel, token)
+}
+
+// authenticate returns the token of the request, or nil if it has none or
+// an unknown one.
+func (s *server) authenticate(r *http.Request) *apiToken {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil
+	}
+	secret := []byte(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+
+	for i := range s.tokens {
+		if subtle.ConstantTimeCompare(secret, []byte(s.tokens[i].Token)) == 1 {
+			return &s.tokens[i]
+		}
+	}
+	return nil
+}
+
+// historyMax is the number of revisions searched for older revisions of a
+// release, the default history limit of Helm.
+const historyMax = 256
+
+// revision returns the given revision of a release, or rel for zero.
+func revision(backend releaseBackend, rel *release.Release, version int32) (*release.Release, error) {
+	if version == 0 || version == rel.Version {
+		return rel, nil
+	}
+
+	hist, err := backend.History(rel.Name, historyMax)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hist {
+		if h.Version == version {
+			return h, nil
+		}
+	}
+	return nil, errorf(http.StatusNotFound, "release %s has no revision %d", rel.Name, version)
+}
+
+// queryRevision parses a revision query parameter, which is zero if unset.
+func queryRevision(r *http.Request, param string) (int32, error) {
+	s := r.URL.Query().Get(param)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || n < 1 {
+		return 0, errorf(http.StatusBadRequest, "invalid %s %q", param, s)
+	}
+	return int32(n), nil
+}
+
+// configValues returns the user-supplied config of rel, or all its values
+// including the defaults of the chart.
+func configValues(rel *release.Release, all bool) (map[string]interface{}, error) {
+	if all {
+		return chartutil.CoalesceValues(rel.Chart, rel.Config)
+	}
+	return chartutil.ReadValues([]byte(rel.GetConfig().GetRaw()))
+}
+
+// apiConfig is the response of GET /v1/releases/NAME/config.
+type apiConfig struct {
+	Release   string                 `json:"release"`
+	Namespace string                 `json:"namespace"`
+	Revision  int32                  `json:"revision"`
+	Chart     chartResult            `json:"chart"`
+	All       bool                   `json:"all"`
+	Values    map[string]interface{} `json:"values"`
+}
+
+func (s *server) getConfig(w http.ResponseWriter, r *http.Request, backend releaseBackend, rel *release.Release, _ *apiToken) error {
+	version, err := queryRevision(r, "revision")
+	if err != nil {
+		return err
+	}
+	at, err := revision(backend, rel, version)
+	if err != nil {
+		return err
+	}
+
+	all := r.URL.Query().Get("all") == "true"
+	vals, err := configValues(at, all)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(int(at.Version))))
+	return writeJSON(w, http.StatusOK, apiConfig{
+		Release:   at.Name,
+		Namespace: at.Namespace,
+		Revision:  at.Version,
+		Chart:     chartResult{Name: at.GetChart().GetMetadata().GetName(), Version: at.GetChart().GetMetadata().GetVersion()},
+		All:       all,
+		Values:    s.redactor.Values(vals),
+	})
+}
+
+// apiRevision is a revision in the response of GET /v1/releases/NAME/history.
+type apiRevision struct {
+	Revision    int32       `json:"revision"`
+	Status      string      `json:"status"`
+	Updated     time.Time   `json:"updated"`
+	Chart       chartResult `json:"chart"`
+	Description string      `json:"description"`
+}
+
+func (s *server) getHistory(w http.ResponseWriter, r *http.Request, backend releaseBackend, rel *release.Release, _ *apiToken) error {
+	max := int32(20)
+	if v := r.URL.Query().Get("max"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || n < 1 {
+			return errorf(http.StatusBadRequest, "invalid max %q", v)
+		}
+		max = int32(n)
+	}
+
+	hist, err := backend.History(rel.Name, max)
+	if err != nil {
+		return err
+	}
+
+	revs := []apiRevision{}
+	for _, h := range hist {
+		var updated time.Time
+		if ts := h.GetInfo().GetLastDeployed(); ts != nil {
+			updated = time.Unix(ts.Seconds, int64(ts.Nanos)).UTC()
+		}
+		revs = append(revs, apiRevision{
+			Revision:    h.Version,
+			Status:      h.GetInfo().GetStatus().GetCode().String(),
+			Updated:     updated,
+			Chart:       chartResult{Name: h.GetChart().GetMetadata().GetName(), Version: h.GetChart().GetMetadata().GetVersion()},
+			Description: h.GetInfo().GetDescription(),
+		})
+	}
+
+	return writeJSON(w, http.StatusOK, revs)
+}
+
+// apiDiff is the response of GET /v1/releases/NAME/diff.
+type apiDiff struct {
+	Release string           `json:"release"`
+	From    int32            `json:"from"`
+	To      int32            `json:"to"`
+	All     bool             `json:"all"`
+	Changes []updater.Change `json:"changes"`
+}
+
+func (s *server) getDiff(w http.ResponseWriter, r *http.Request, backend releaseBackend, rel *release.Release, _ *apiToken) error {
+	to, err := queryRevision(r, "to")
+	if err != nil {
+		return err
+	}
+	if to == 0 {
+		to = rel.Version
+	}
+	from, err := queryRevision(r, "from")
+	if err != nil {
+		return err
+	}
+	if from == 0 {
+		from = to - 1
+	}
+	all := r.URL.Query().Get("all") == "true"
+
+	toRel, err := revision(backend, rel, to)
+	if err != nil {
+		return err
+	}
+	toVals, err := configValues(toRel, all)
+	if err != nil {
+		return err
+	}
+
+	fromVals := map[string]interface{}{}
+	if from > 0 {
+		fromRel, err := revision(backend, rel, from)
+		if err != nil {
+			return err
+		}
+		if fromVals, err = configValues(fromRel, all); err != nil {
+			return err
+		}
+	}
+
+	changes := s.redactor.Changes(updater.DiffValues(fromVals, toVals))
+	if changes == nil {
+		changes = []updater.Change{}
+	}
+
+	return writeJSON(w, http.StatusOK, apiDiff{Release: rel.Name, From: from, To: to, All: all, Changes: changes})
+}
+
+// apiUpdateRequest is the body of POST /v1/releases/NAME/dry-run and apply.
+type apiUpdateRequest struct {
+	Values         map[string]interface{} `json:"values"`
+	Set            []string               `json:"set"`
+	SetTemplates   []string               `json:"setTemplates"`
+	ResetValues    bool                   `json:"resetValues"`
+	Preconditions  []apiPrecondition      `json:"preconditions"`
+	PinDigests     bool                   `json:"pinDigests"`
+	SkipImageCheck bool                   `json:"skipImageCheck"`
+	Wait           bool                   `json:"wait"`
+	Timeout        int64                  `json:"timeout"`
+}
+
+type apiPrecondition struct {
+	Path   string `json:"path"`
+	Value  string `json:"value"`
+	Absent bool   `json:"absent"`
+}
+
+// apiUpdateResult is the response of an update: the document printed by
+// -o json, along with the redacted changes.
+type apiUpdateResult struct {
+	*updateResult
+	Changes []updater.Change `json:"changes"`
+}
+
+// updateOptions reads the options of an update from the request.
+func (s *server) updateOptions(r *http.Request, name string) (updater.Options, error) {
+	var req apiUpdateRequest
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return updater.Options{}, errorf(http.StatusBadRequest, "invalid request body: %s", err)
+	}
+
+	opts := updater.Options{
+		Release:      name,
+		Values:       req.Values,
+		Set:          req.Set,
+		SetTemplates: req.SetTemplates,
+		ResetValues:  req.ResetValues,
+		PinDigests:   req.PinDigests,
+		ImagePaths:   s.cfg.Images.Paths,
+		CheckImages:  !req.SkipImageCheck,
+		Registry:     updater.NewRegistry(),
+		Redactor:     s.redactor,
+		Wait:         req.Wait,
+		Timeout:      req.Timeout,
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 300
+	}
+	for _, p := range req.Preconditions {
+		opts.Preconditions = append(opts.Preconditions, updater.Precondition{Path: p.Path, Value: p.Value, Absent: p.Absent})
+	}
+
+	if m := r.Header.Get("If-Match"); m != "" {
+		m = strings.Trim(strings.TrimPrefix(m, "W/"), `"`)
+		n, err := strconv.ParseInt(m, 10, 32)
+		if err != nil || n < 1 {
+			return opts, errorf(http.StatusBadRequest, "If-Match has to be a revision, got %q", r.Header.Get("If-Match"))
+		}
+		opts.IfRevision = int32(n)
+	}
+
+	return opts, nil
+}
+
+func (s *server) dryRun(w http.ResponseWriter, r *http.Request, backend releaseBackend, rel *release.Release, _ *apiToken) error {
+	opts, err := s.updateOptions(r, rel.Name)
+	if err != nil {
+		return err
+	}
+	opts.DryRun = true
+
+	defer s.lock(rel.Name)()
+
+	res, err := updater.UpdateBackend(r.Context(), backend, opts)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, apiUpdateResult{updateResult: newUpdateResult(res), Changes: res.Changes})
+}
+
+func (s *server) apply(w http.ResponseWriter, r *http.Request, backend releaseBackend, rel *release.Release, token *apiToken) error {
+	opts, err := s.updateOptions(r, rel.Name)
+	if err != nil {
+		return err
+	}
+
+	defer s.lock(rel.Name)()
+
+	update := &updateConfigCommand{
+		backend:  backend,
+		opts:     opts,
+		journal:  s.cfg.journal(),
+		notifier: s.notifier,
+		token:    token.Name,
+	}
+	res, err := update.run()
+	s.metrics.update(outcomeOf(err))
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(int(res.Revision))))
+	return writeJSON(w, http.StatusOK, apiUpdateResult{updateResult: newUpdateResult(res), Changes: res.Changes})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
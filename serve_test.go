@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+)
+
+func newTestServer(t *testing.T, backend releaseBackend) *server {
+	t.Helper()
+	r, err := updater.NewRedactor(updater.RedactConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &server{
+		cfg:      &config{Journal: journalConfig{Disabled: true}},
+		redactor: r,
+		tokens: []apiToken{
+			{Name: "ci", Token: "ci-secret"},
+			{Name: "viewer", Token: "viewer-secret", ReadOnly: true},
+			{Name: "api-team", Token: "api-secret", Releases: []string{"api-*"}},
+		},
+		metrics:    newServerMetrics(),
+		log:        log.New(ioutil.Discard, "", 0),
+		newBackend: func() (releaseBackend, error) { return backend, nil },
+		locks:      make(map[string]*sync.Mutex),
+	}
+}
+
+// serveRequest sends a request with a token and optional If-Match header to s.
+func serveRequest(s *server, method, path, token, ifMatch, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestServeIfMatch(t *testing.T) {
+	backend := newFakeBackend(fakeRelease("web", "prod", 1, "", "replicas: 1\n"))
+	s := newTestServer(t, backend)
+
+	w := serveRequest(s, "GET", "/v1/releases/web/config", "ci-secret", "", "")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("got %d with ETag %s, want 200 and \"1\"", w.Code, w.Header().Get("ETag"))
+	}
+
+	body := `{"set": ["replicas=2"], "skipImageCheck": true}`
+	tests := []struct {
+		path    string
+		ifMatch string
+		code    int
+		etag    string
+	}{
+		{"/v1/releases/web/dry-run", `"1"`, http.StatusOK, ""},
+		{"/v1/releases/web/apply", `"1"`, http.StatusOK, `"2"`},
+		// The release has moved on since revision 1.
+		{"/v1/releases/web/apply", `"1"`, http.StatusPreconditionFailed, ""},
+		{"/v1/releases/web/dry-run", `"1"`, http.StatusPreconditionFailed, ""},
+		{"/v1/releases/web/apply", `W/"2"`, http.StatusOK, `"3"`},
+		{"/v1/releases/web/apply", `"latest"`, http.StatusBadRequest, ""},
+		{"/v1/releases/web/apply", "", http.StatusOK, `"4"`},
+	}
+	for _, tt := range tests {
+		w := serveRequest(s, "POST", tt.path, "ci-secret", tt.ifMatch, body)
+		if w.Code != tt.code {
+			t.Errorf("%s with If-Match %s: got %d, want %d: %s", tt.path, tt.ifMatch, w.Code, tt.code, w.Body)
+			continue
+		}
+		if w.Header().Get("ETag") != tt.etag {
+			t.Errorf("%s with If-Match %s: got ETag %s, want %s", tt.path, tt.ifMatch, w.Header().Get("ETag"), tt.etag)
+		}
+	}
+
+	if n := len(backend.releases["web"]); n != 4 {
+		t.Errorf("got %d revisions, want 4", n)
+	}
+
+	w = serveRequest(s, "POST", "/v1/releases/web/apply", "ci-secret", `"2"`, body)
+	var resp map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(resp["error"], "revision") {
+		t.Errorf("got error %q, want it to name the revisions", resp["error"])
+	}
+}
+
+func TestServeIfMatchMasked(t *testing.T) {
+	// The name of the release is also one of its secrets, so it is masked in
+	// the error, which is still a failed precondition.
+	backend := newFakeBackend(
+		fakeRelease("hunter22", "prod", 1, "", "password: hunter22\n"),
+		fakeRelease("hunter22", "prod", 2, "", "password: hunter22\n"),
+	)
+	s := newTestServer(t, backend)
+
+	w := serveRequest(s, "POST", "/v1/releases/hunter22/apply", "ci-secret", `"1"`, `{"set": ["replicas=2"], "skipImageCheck": true}`)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("got %d, want %d: %s", w.Code, http.StatusPreconditionFailed, w.Body)
+	}
+	if strings.Contains(w.Body.String(), "hunter22") {
+		t.Errorf("password leaked into the error: %s", w.Body)
+	}
+}
+
+func TestServeAccess(t *testing.T) {
+	backend := newFakeBackend(
+		fakeRelease("web", "prod", 1, "", "db:\n  password: hunter22\n"),
+		fakeRelease("api-v1", "prod", 1, "", "replicas: 1\n"),
+	)
+	s := newTestServer(t, backend)
+
+	tests := []struct {
+		method, path, token string
+		code                int
+	}{
+		{"GET", "/v1/releases/web/config", "", http.StatusUnauthorized},
+		{"GET", "/v1/releases/web/config", "wrong", http.StatusUnauthorized},
+		{"GET", "/v1/releases/web/config", "viewer-secret", http.StatusOK},
+		{"POST", "/v1/releases/web/apply", "viewer-secret", http.StatusForbidden},
+		{"POST", "/v1/releases/web/dry-run", "viewer-secret", http.StatusOK},
+		{"GET", "/v1/releases/web/apply", "ci-secret", http.StatusMethodNotAllowed},
+		{"GET", "/v1/releases/api-v1/config", "api-secret", http.StatusOK},
+		{"GET", "/v1/releases/web/config", "api-secret", http.StatusForbidden},
+		// Tokens without access do not learn whether a release exists.
+		{"GET", "/v1/releases/missing/config", "api-secret", http.StatusForbidden},
+		{"GET", "/v1/releases/missing/config", "ci-secret", http.StatusNotFound},
+		{"GET", "/v1/releases/web/unknown", "ci-secret", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		body := ""
+		if tt.method == "POST" {
+			body = `{"set": ["replicas=2"], "skipImageCheck": true}`
+		}
+		w := serveRequest(s, tt.method, tt.path, tt.token, "", body)
+		if w.Code != tt.code {
+			t.Errorf("%s %s with token %q: got %d, want %d: %s", tt.method, tt.path, tt.token, w.Code, tt.code, w.Body)
+		}
+	}
+
+	w := serveRequest(s, "GET", "/v1/releases/web/config", "ci-secret", "", "")
+	if strings.Contains(w.Body.String(), "hunter22") {
+		t.Errorf("password leaked into the config:\n%s", w.Body)
+	}
+}
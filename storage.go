@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/ghodss/yaml"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// storageBackend reads and writes Helm 3 releases, which are stored by the
+// Helm client itself as Kubernetes Secrets or ConfigMaps, without Tiller.
+//
+// Helm 3 renders charts on the client, which this plugin cannot do. A new
+// revision gets the new config but keeps the manifest of the previous one,
+// so the change reaches the cluster with the next `helm upgrade --reuse-values`.
+type storageBackend struct {
+	kube   *kubeClient
+	driver string
+	// namespace limits the backend to a single namespace. If it is empty,
+	// releases are looked up in all namespaces.
+	namespace string
+}
+
+// storageObject is a Secret or ConfigMap holding one revision of a release.
+type storageObject struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   storageObjectMeta `json:"metadata"`
+	Type       string            `json:"type,omitempty"`
+	Data       map[string]string `json:"data"`
+}
+
+type storageObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+}
+
+// storedRelease is a decoded revision together with the object it came from.
+type storedRelease struct {
+	object *storageObject
+	// raw is the release as Helm 3 serialized it. It is kept as a generic
+	// document so fields unknown to this plugin survive a rewrite.
+	raw     map[string]interface{}
+	release *release.Release
+}
+
+// helm3Release holds the fields of a Helm 3 release needed to convert it.
+type helm3Release struct {
+	Name      string                 `json:"name"`
+	Namespace string                 `json:"namespace"`
+	Version   int32                  `json:"version"`
+	Manifest  string                 `json:"manifest"`
+	Config    map[string]interface{} `json:"config"`
+	Info      struct {
+		FirstDeployed string `json:"first_deployed"`
+		LastDeployed  string `json:"last_deployed"`
+		Description   string `json:"description"`
+		Status        string `json:"status"`
+		Notes         string `json:"notes"`
+	} `json:"info"`
+	Chart struct {
+		Metadata  *chart.Metadata        `json:"metadata"`
+		Templates []*chart.Template      `json:"templates"`
+		Values    map[string]interface{} `json:"values"`
+		Files     []struct {
+			Name string `json:"name"`
+			Data []byte `json:"data"`
+		} `json:"files"`
+	} `json:"chart"`
+}
+
+var helm3Statuses = map[string]release.Status_Code{
+	"deployed":         release.Status_DEPLOYED,
+	"uninstalled":      release.Status_DELETED,
+	"superseded":       release.Status_SUPERSEDED,
+	"failed":           release.Status_FAILED,
+	"uninstalling":     release.Status_DELETING,
+	"pending-install":  release.Status_PENDING_INSTALL,
+	"pending-upgrade":  release.Status_PENDING_UPGRADE,
+	"pending-rollback": release.Status_PENDING_ROLLBACK,
+}
+
+func (b *storageBackend) Close() error {
+	return nil
+}
+
+func (b *storageBackend) resource() string {
+	if b.driver == backendConfigMap {
+		return "configmaps"
+	}
+	return "secrets"
+}
+
+func (b *storageBackend) path(namespace, name string) string {
+	p := "/api/v1/" + b.resource()
+	if namespace != "" {
+		p = "/api/v1/namespaces/" + url.PathEscape(namespace) + "/" + b.resource()
+	}
+	if name != "" {
+		p += "/" + url.PathEscape(name)
+	}
+	return p
+}
+
+func (b *storageBackend) list(selector string) ([]*storedRelease, error) {
+	var list struct {
+		Items []*storageObject `json:"items"`
+	}
+
+	q := url.Values{"labelSelector": {selector}}
+	if err := b.kube.do("GET", b.path(b.namespace, ""), q, nil, &list); err != nil {
+		return nil, err
+	}
+
+	var rels []*storedRelease
+	for _, obj := range list.Items {
+		r, err := b.decode(obj)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %s", obj.Metadata.Namespace, obj.Metadata.Name, err)
+		}
+		rels = append(rels, r)
+	}
+
+	return rels, nil
+}
+
+// revisions returns all revisions of a release, oldest first.
+func (b *storageBackend) revisions(name string) ([]*storedRelease, error) {
+	rels, err := b.list("owner=helm,name=" + name)
+	if err != nil {
+		return nil, err
+	}
+	if len(rels) == 0 {
+		return nil, fmt.Errorf("release: %q not found", name)
+	}
+
+	ns := rels[0].release.Namespace
+	for _, r := range rels[1:] {
+		if r.release.Namespace != ns {
+			return nil, fmt.Errorf("release %q exists in namespaces %q and %q, select one with --namespace", name, ns, r.release.Namespace)
+		}
+	}
+
+	sort.Slice(rels, func(i, j int) bool { return rels[i].release.Version < rels[j].release.Version })
+	return rels, nil
+}
+
+func (b *storageBackend) Release(name string) (*release.Release, error) {
+	revs, err := b.revisions(name)
+	if err != nil {
+		return nil, err
+	}
+	return revs[len(revs)-1].release, nil
+}
+
+func (b *storageBackend) ListReleases() ([]*release.Release, error) {
+	rels, err := b.list("owner=helm")
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]*release.Release)
+	for _, r := range rels {
+		key := r.release.Namespace + "/" + r.release.Name
+		if l, ok := latest[key]; !ok || r.release.Version > l.Version {
+			latest[key] = r.release
+		}
+	}
+
+	var out []*release.Release
+	for _, r := range latest {
+		if r.Info.Status.Code != release.Status_DELETED {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return out, nil
+}
+
+func (b *storageBackend) History(name string, max int32) ([]*release.Release, error) {
+	revs, err := b.revisions(name)
+	if err != nil {
+		return nil, err
+	}
+	if max > 0 && int(max) < len(revs) {
+		revs = revs[len(revs)-int(max):]
+	}
+
+	var out []*release.Release
+	for _, r := range revs {
+		out = append(out, r.release)
+	}
+	return out, nil
+}
+
+func (b *storageBackend) Update(current *release.Release, req updater.Request) (*release.Release, error) {
+	revs, err := b.revisions(current.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	prev := revs[len(revs)-1]
+	if prev.release.Version != current.Version {
+		return nil, &updater.ConflictError{Release: current.Name, Err: fmt.Errorf("release %s has been changed: revision %d is the latest, not %d", current.Name, prev.release.Version, current.Version)}
+	}
+
+	vals, err := decodeYAMLValues(req.Values)
+	if err != nil {
+		return nil, err
+	}
+	if req.ReuseValues {
+		old, _ := updater.DeepCopy(prev.raw["config"]).(map[string]interface{})
+		vals, _ = updater.ApplyMergePatch(old, vals).(map[string]interface{})
+	}
+
+	raw := updater.DeepCopy(prev.raw).(map[string]interface{})
+	raw["config"] = vals
+	raw["version"] = prev.release.Version + 1
+	info, _ := raw["info"].(map[string]interface{})
+	if info == nil {
+		info = map[string]interface{}{}
+	}
+	info["last_deployed"] = time.Now().UTC().Format(time.RFC3339Nano)
+	info["status"] = "deployed"
+	info["description"] = fmt.Sprintf("Config updated by helm update-config, with the manifest of revision %d", prev.release.Version)
+	raw["info"] = info
+
+	next, err := b.encode(raw, prev.object)
+	if err != nil {
+		return nil, err
+	}
+	if req.DryRun {
+		return next.release, nil
+	}
+
+	// Creating the object fails if another client has written the revision
+	// in the meantime, just like Helm 3 itself.
+	ns := prev.release.Namespace
+	if err := b.kube.do("POST", b.path(ns, ""), nil, next.object, nil); err != nil {
+		if e, ok := err.(*kubeAPIError); ok && e.Code == http.StatusConflict {
+			return nil, &updater.ConflictError{Release: current.Name, Err: fmt.Errorf("release %s has been changed: revision %d has been written by another client", current.Name, next.release.Version)}
+		}
+		return nil, err
+	}
+
+	old := updater.DeepCopy(prev.raw).(map[string]interface{})
+	if oldInfo, ok := old["info"].(map[string]interface{}); ok {
+		oldInfo["status"] = "superseded"
+	}
+	superseded, err := b.encode(old, prev.object)
+	if err != nil {
+		return nil, err
+	}
+	superseded.object.Metadata.ResourceVersion = prev.object.Metadata.ResourceVersion
+	if err := b.kube.do("PUT", b.path(ns, prev.object.Metadata.Name), nil, superseded.object, nil); err != nil {
+		return nil, fmt.Errorf("revision %d has been written, but revision %d could not be marked as superseded: %s", next.release.Version, prev.release.Version, err)
+	}
+
+	return next.release, nil
+}
+
+// decode reads a release the way Helm 3 stores it: gzipped JSON encoded in
+// base64, in the "release" key. Secret data is base64 encoded once more by
+// the API server.
+func (b *storageBackend) decode(obj *storageObject) (*storedRelease, error) {
+	data := obj.Data["release"]
+	if b.driver == backendSecret {
+		d, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, err
+		}
+		data = string(d)
+	}
+
+	d, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(d, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(d))
+		if err != nil {
+			return nil, err
+		}
+		if d, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+
+	doc, err := updater.DecodeJSON(d)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("release is not a JSON object")
+	}
+
+	rel, err := toRelease(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storedRelease{object: obj, raw: raw, release: rel}, nil
+}
+
+// encode builds the object storing raw, taking the name prefix and kind from
+// an existing revision.
+func (b *storageBackend) encode(raw map[string]interface{}, like *storageObject) (*storedRelease, error) {
+	d, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(d); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+	if b.driver == backendSecret {
+		data = base64.StdEncoding.EncodeToString([]byte(data))
+	}
+
+	var h helm3Release
+	if err := json.Unmarshal(d, &h); err != nil {
+		return nil, err
+	}
+	rel, err := toRelease(d)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &storageObject{
+		APIVersion: "v1",
+		Kind:       like.Kind,
+		Type:       like.Type,
+		Metadata: storageObjectMeta{
+			Name:      fmt.Sprintf("sh.helm.release.v1.%s.v%d", h.Name, h.Version),
+			Namespace: h.Namespace,
+			Labels: map[string]string{
+				"name":    h.Name,
+				"owner":   "helm",
+				"status":  h.Info.Status,
+				"version": strconv.Itoa(int(h.Version)),
+			},
+		},
+		Data: map[string]string{"release": data},
+	}
+	if obj.Kind == "" {
+		obj.Kind = "Secret"
+		if b.driver == backendConfigMap {
+			obj.Kind = "ConfigMap"
+		}
+	}
+
+	return &storedRelease{object: obj, raw: raw, release: rel}, nil
+}
+
+// toRelease converts a Helm 3 release serialized as JSON.
+func toRelease(data []byte) (*release.Release, error) {
+	var h helm3Release
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&h); err != nil {
+		return nil, err
+	}
+
+	config, err := yaml.Marshal(h.Config)
+	if err != nil {
+		return nil, err
+	}
+	values, err := yaml.Marshal(h.Chart.Values)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := &chart.Chart{
+		Metadata:  h.Chart.Metadata,
+		Templates: h.Chart.Templates,
+		Values:    &chart.Config{Raw: string(values)},
+	}
+	for _, f := range h.Chart.Files {
+		ch.Files = append(ch.Files, &any.Any{TypeUrl: f.Name, Value: f.Data})
+	}
+
+	return &release.Release{
+		Name:      h.Name,
+		Namespace: h.Namespace,
+		Version:   h.Version,
+		Manifest:  h.Manifest,
+		Chart:     ch,
+		Config:    &chart.Config{Raw: string(config)},
+		Info: &release.Info{
+			Status: &release.Status{
+				Code:  helm3Statuses[h.Info.Status],
+				Notes: h.Info.Notes,
+			},
+			FirstDeployed: parseTimestamp(h.Info.FirstDeployed),
+			LastDeployed:  parseTimestamp(h.Info.LastDeployed),
+			Description:   h.Info.Description,
+		},
+	}, nil
+}
+
+func parseTimestamp(s string) *timestamp.Timestamp {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil
+	}
+	return &timestamp.Timestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())}
+}
+
+func decodeYAMLValues(data []byte) (map[string]interface{}, error) {
+	j, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := updater.DecodeJSON(j)
+	if err != nil {
+		return nil, err
+	}
+
+	vals, ok := doc.(map[string]interface{})
+	if !ok {
+		vals = map[string]interface{}{}
+	}
+	return vals, nil
+}
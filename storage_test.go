@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// fakeKubeAPI serves Helm 3 release objects the way the Kubernetes API
+// server lists them, filtered by namespace and label selector. Objects are
+// created with POST and replaced with PUT, which fail with a conflict like
+// the API server does.
+type fakeKubeAPI struct {
+	mu      sync.Mutex
+	objects []*storageObject
+	// paths are the paths of all requests, writes the methods and paths of
+	// the ones that are not reads.
+	paths  []string
+	writes []string
+	// version is the last resource version handed out.
+	version int
+}
+
+func (a *fakeKubeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.paths = append(a.paths, r.URL.Path)
+
+	parts := strings.Split(r.URL.Path, "/")
+	var ns string
+	if len(parts) >= 6 && parts[3] == "namespaces" {
+		ns = parts[4]
+	}
+
+	if r.Method != "GET" {
+		a.writes = append(a.writes, r.Method+" "+r.URL.Path)
+		var obj storageObject
+		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil || obj.Metadata.Namespace != ns {
+			http.Error(w, `{"code": 400}`, http.StatusBadRequest)
+			return
+		}
+		i := a.find(ns, obj.Metadata.Name)
+		switch {
+		case r.Method == "POST" && i >= 0:
+			http.Error(w, `{"code": 409, "reason": "AlreadyExists"}`, http.StatusConflict)
+		case r.Method == "POST":
+			a.version++
+			obj.Metadata.ResourceVersion = fmt.Sprint(a.version)
+			a.objects = append(a.objects, &obj)
+			json.NewEncoder(w).Encode(obj)
+		case r.Method == "PUT" && (i < 0 || len(parts) != 7 || parts[6] != obj.Metadata.Name):
+			http.Error(w, `{"code": 404}`, http.StatusNotFound)
+		case r.Method == "PUT" && a.objects[i].Metadata.ResourceVersion != obj.Metadata.ResourceVersion:
+			http.Error(w, `{"code": 409, "reason": "Conflict"}`, http.StatusConflict)
+		case r.Method == "PUT":
+			a.version++
+			obj.Metadata.ResourceVersion = fmt.Sprint(a.version)
+			a.objects[i] = &obj
+			json.NewEncoder(w).Encode(obj)
+		default:
+			http.Error(w, `{"code": 405}`, http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	items := []*storageObject{}
+	for _, obj := range a.objects {
+		if (ns == "" || obj.Metadata.Namespace == ns) && matchLabels(r.URL.Query().Get("labelSelector"), obj.Metadata.Labels) {
+			items = append(items, obj)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+func (a *fakeKubeAPI) find(namespace, name string) int {
+	for i, obj := range a.objects {
+		if obj.Metadata.Namespace == namespace && obj.Metadata.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func matchLabels(selector string, labels map[string]string) bool {
+	for _, s := range strings.Split(selector, ",") {
+		if kv := strings.SplitN(s, "=", 2); len(kv) == 2 && labels[kv[0]] != kv[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// helm3Object encodes a release as Helm 3 stores it with driver.
+func helm3Object(t *testing.T, driver string, gzipped bool, name, namespace string, version int, status, config string) *storageObject {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]interface{}{
+		"name":      name,
+		"namespace": namespace,
+		"version":   version,
+		"manifest":  "kind: Deployment\n",
+		"config":    json.RawMessage(config),
+		"info":      map[string]interface{}{"status": status, "last_deployed": "2020-01-02T03:04:05Z"},
+		"chart": map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "app", "version": "1.0.0"},
+			"values":   map[string]interface{}{"replicas": 1},
+		},
+		// Unknown to the plugin, has to survive a rewrite.
+		"labels": map[string]interface{}{"team": "web"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gzipped {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		data = buf.Bytes()
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	kind := "ConfigMap"
+	if driver == backendSecret {
+		kind = "Secret"
+		encoded = base64.StdEncoding.EncodeToString([]byte(encoded))
+	}
+
+	return &storageObject{
+		APIVersion: "v1",
+		Kind:       kind,
+		Metadata: storageObjectMeta{
+			Name:      fmt.Sprintf("sh.helm.release.v1.%s.v%d", name, version),
+			Namespace: namespace,
+			Labels:    map[string]string{"name": name, "owner": "helm", "status": status, "version": fmt.Sprint(version)},
+		},
+		Data: map[string]string{"release": encoded},
+	}
+}
+
+func newTestStorageBackend(t *testing.T, driver, namespace string, objects ...*storageObject) (*storageBackend, *fakeKubeAPI, func()) {
+	api := &fakeKubeAPI{objects: objects}
+	for _, obj := range objects {
+		api.version++
+		obj.Metadata.ResourceVersion = fmt.Sprint(api.version)
+	}
+	srv := httptest.NewServer(api)
+	kube := &kubeClient{server: srv.URL, http: srv.Client()}
+	return &storageBackend{kube: kube, driver: driver, namespace: namespace}, api, srv.Close
+}
+
+func TestStorageBackendRead(t *testing.T) {
+	for _, driver := range []string{backendSecret, backendConfigMap} {
+		t.Run(driver, func(t *testing.T) {
+			b, api, done := newTestStorageBackend(t, driver, "",
+				helm3Object(t, driver, true, "web", "prod", 1, "superseded", `{"image": {"tag": "v1"}}`),
+				helm3Object(t, driver, false, "web", "prod", 2, "deployed", `{"image": {"tag": "v2"}}`),
+				helm3Object(t, driver, true, "db", "prod", 1, "uninstalled", `{}`),
+				helm3Object(t, driver, true, "api", "dev", 3, "failed", `{}`),
+			)
+			defer done()
+
+			rel, err := b.Release("web")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rel.Version != 2 || rel.Namespace != "prod" || rel.Info.Status.Code != release.Status_DEPLOYED {
+				t.Errorf("unexpected release %s", rel)
+			}
+			if want := "image:\n  tag: v2\n"; rel.Config.Raw != want {
+				t.Errorf("got config %q, want %q", rel.Config.Raw, want)
+			}
+			if want := "replicas: 1\n"; rel.Chart.Values.Raw != want {
+				t.Errorf("got chart values %q, want %q", rel.Chart.Values.Raw, want)
+			}
+			if rel.Info.LastDeployed.GetSeconds() != 1577934245 {
+				t.Errorf("got last deployed %v", rel.Info.LastDeployed)
+			}
+
+			hist, err := b.History("web", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hist) != 2 || hist[0].Version != 1 || hist[1].Version != 2 {
+				t.Errorf("unexpected history %v", hist)
+			}
+
+			rels, err := b.ListReleases()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, r := range rels {
+				names = append(names, r.Name)
+			}
+			if got := strings.Join(names, ","); got != "api,web" {
+				t.Errorf("got releases %s, want the ones not uninstalled", got)
+			}
+
+			if _, err := b.Release("missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+				t.Errorf("got error %v for a missing release", err)
+			}
+
+			for _, p := range api.paths {
+				if p != "/api/v1/"+b.resource() {
+					t.Errorf("unexpected request to %s", p)
+				}
+			}
+		})
+	}
+}
+
+func TestStorageBackendNamespace(t *testing.T) {
+	b, api, done := newTestStorageBackend(t, backendSecret, "",
+		helm3Object(t, backendSecret, true, "web", "prod", 1, "deployed", `{}`),
+		helm3Object(t, backendSecret, true, "web", "dev", 1, "deployed", `{}`),
+	)
+	defer done()
+
+	if _, err := b.Release("web"); err == nil || !strings.Contains(err.Error(), "select one with --namespace") {
+		t.Errorf("got error %v for a release in two namespaces", err)
+	}
+
+	b.namespace = "dev"
+	rel, err := b.Release("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel.Namespace != "dev" {
+		t.Errorf("got release in namespace %s", rel.Namespace)
+	}
+	if p := api.paths[len(api.paths)-1]; p != "/api/v1/namespaces/dev/secrets" {
+		t.Errorf("got request to %s", p)
+	}
+}
+
+func TestStorageBackendUpdate(t *testing.T) {
+	for _, driver := range []string{backendSecret, backendConfigMap} {
+		t.Run(driver, func(t *testing.T) {
+			b, api, done := newTestStorageBackend(t, driver, "",
+				helm3Object(t, driver, true, "web", "prod", 1, "deployed", `{"image": {"tag": "v1"}, "replicas": 2}`),
+			)
+			defer done()
+
+			current, err := b.Release("web")
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := updater.Request{Values: []byte("image:\n  tag: v2\n"), ReuseValues: true}
+
+			dryReq := req
+			dryReq.DryRun = true
+			dry, err := b.Update(current, dryReq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dry.Version != 2 || len(api.writes) > 0 {
+				t.Errorf("dry run wrote %v and returned revision %d", api.writes, dry.Version)
+			}
+
+			next, err := b.Update(current, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "image:\n  tag: v2\nreplicas: 2\n"; next.Config.Raw != want {
+				t.Errorf("got config %q, want %q", next.Config.Raw, want)
+			}
+			if next.Manifest != current.Manifest {
+				t.Errorf("got manifest %q, want the one of the current revision", next.Manifest)
+			}
+			wantWrites := []string{"POST " + b.path("prod", ""), "PUT " + b.path("prod", "sh.helm.release.v1.web.v1")}
+			if strings.Join(api.writes, ",") != strings.Join(wantWrites, ",") {
+				t.Errorf("got writes %v, want %v", api.writes, wantWrites)
+			}
+
+			hist, err := b.History("web", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hist) != 2 || hist[0].Info.Status.Code != release.Status_SUPERSEDED || hist[1].Info.Status.Code != release.Status_DEPLOYED || hist[1].Version != 2 {
+				t.Fatalf("unexpected history %v", hist)
+			}
+			if obj := api.objects[1]; obj.Kind != api.objects[0].Kind || obj.Metadata.Labels["status"] != "deployed" || obj.Metadata.Labels["version"] != "2" {
+				t.Errorf("unexpected object %+v", obj.Metadata)
+			}
+
+			// The release has moved on from the revision the update was
+			// prepared for.
+			if _, err := b.Update(current, req); !updater.IsConflict(err) {
+				t.Errorf("got error %v for an outdated revision, want a conflict", err)
+			}
+		})
+	}
+}
+
+func TestStorageBackendUpdateRace(t *testing.T) {
+	b, api, done := newTestStorageBackend(t, backendSecret, "",
+		helm3Object(t, backendSecret, true, "web", "prod", 1, "deployed", `{}`),
+	)
+	defer done()
+
+	current, err := b.Release("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another client creates revision 2 between the list of the revisions
+	// and the create of the update. Without the label it is not listed.
+	other := helm3Object(t, backendSecret, true, "web", "prod", 2, "deployed", `{}`)
+	delete(other.Metadata.Labels, "name")
+	api.objects = append(api.objects, other)
+
+	if _, err := b.Update(current, updater.Request{Values: []byte("a: 1\n")}); !updater.IsConflict(err) {
+		t.Errorf("got error %v when the revision exists, want a conflict", err)
+	}
+}
+
+func TestStorageBackendEncode(t *testing.T) {
+	for _, driver := range []string{backendSecret, backendConfigMap} {
+		t.Run(driver, func(t *testing.T) {
+			b := &storageBackend{driver: driver}
+
+			stored, err := b.decode(helm3Object(t, driver, true, "web", "prod", 4, "deployed", `{"a": 1}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := b.encode(stored.raw, stored.object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := b.decode(again.object)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !jsonEqual(decoded.raw, stored.raw) {
+				t.Errorf("got %v after a round trip, want %v", decoded.raw, stored.raw)
+			}
+			if decoded.raw["labels"] == nil {
+				t.Error("unknown fields were dropped")
+			}
+			meta := again.object.Metadata
+			if meta.Name != "sh.helm.release.v1.web.v4" || meta.Labels["status"] != "deployed" || meta.Labels["version"] != "4" {
+				t.Errorf("unexpected metadata %+v", meta)
+			}
+		})
+	}
+}
+
+// jsonEqual compares two decoded JSON documents.
+func jsonEqual(a, b interface{}) bool {
+	da, _ := json.Marshal(a)
+	db, _ := json.Marshal(b)
+	return bytes.Equal(da, db)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/spf13/pflag"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+// testBackend is implemented by backends that can run the tests of a release
+// and roll it back.
+type testBackend interface {
+	RunTests(name string, timeout int64, cleanup bool, fn func(*services.TestReleaseResponse)) (int, error)
+	Rollback(name string, version int32, wait bool, timeout int64) error
+}
+
+// testFlags control the release tests run after an update.
+type testFlags struct {
+	enabled bool
+	timeout int64
+	cleanup bool
+	atomic  bool
+}
+
+func (f *testFlags) register(fs *pflag.FlagSet) {
+	fs.BoolVar(&f.enabled, "test", false, "run the tests of the release after the update and fail if any of them fails")
+	fs.Int64Var(&f.timeout, "test-timeout", 300, "time in seconds to wait for any individual test to complete")
+	fs.BoolVar(&f.cleanup, "test-cleanup", false, "delete test pods once the tests have finished")
+	fs.BoolVar(&f.atomic, "atomic", false, "roll back to the previous revision if the tests fail")
+}
+
+// check validates the flags before the update is made.
+func (f *testFlags) check(backend releaseBackend) error {
+	if f.atomic && !f.enabled {
+		return errors.New("--atomic requires --test")
+	}
+	if _, ok := backend.(testBackend); f.enabled && !ok {
+		return fmt.Errorf("--test is not supported by the %s backend", backendName)
+	}
+	return nil
+}
+
+// run tests the new revision of an update, writing the messages of the tests
+// to w. With --atomic the release is rolled back if the tests fail.
+func (f *testFlags) run(backend releaseBackend, res *updater.Result, wait bool, w io.Writer) error {
+	tb := backend.(testBackend)
+
+	failed, err := tb.RunTests(res.Release, f.timeout, f.cleanup, func(r *services.TestReleaseResponse) {
+		fmt.Fprintln(w, r.Msg)
+	})
+	if err == nil && failed == 0 {
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("%d test(s) failed for release %s", failed, res.Release)
+	}
+
+	if !f.atomic {
+		return err
+	}
+
+	if rerr := tb.Rollback(res.Release, res.PreviousRevision, wait, f.timeout); rerr != nil {
+		return fmt.Errorf("%s; rolling back to revision %d failed: %s", err, res.PreviousRevision, rerr)
+	}
+	return fmt.Errorf("%s; rolled back to revision %d", err, res.PreviousRevision)
+}
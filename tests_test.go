@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+// fakeTestBackend runs tests that fail and records rollbacks.
+type fakeTestBackend struct {
+	*fakeBackend
+	failed     int
+	rolledBack []int32
+}
+
+func (b *fakeTestBackend) RunTests(name string, timeout int64, cleanup bool, fn func(*services.TestReleaseResponse)) (int, error) {
+	fn(&services.TestReleaseResponse{Msg: "RUNNING: " + name + "-test"})
+	return b.failed, nil
+}
+
+func (b *fakeTestBackend) Rollback(name string, version int32, wait bool, timeout int64) error {
+	b.rolledBack = append(b.rolledBack, version)
+	return nil
+}
+
+func TestRunTests(t *testing.T) {
+	res := &updater.Result{Release: "web", PreviousRevision: 1, Revision: 2}
+
+	tests := []struct {
+		name       string
+		failed     int
+		atomic     bool
+		err        string
+		rolledBack int
+	}{
+		{"passed", 0, true, "", 0},
+		{"failed", 1, false, "1 test(s) failed for release web", 0},
+		{"rolled back", 2, true, "2 test(s) failed for release web; rolled back to revision 1", 1},
+	}
+	for _, tt := range tests {
+		backend := &fakeTestBackend{fakeBackend: newFakeBackend(), failed: tt.failed}
+		f := &testFlags{enabled: true, atomic: tt.atomic}
+
+		var out bytes.Buffer
+		err := f.run(backend, res, false, &out)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+		if len(backend.rolledBack) != tt.rolledBack {
+			t.Errorf("%s: got rollbacks to %v, want %d", tt.name, backend.rolledBack, tt.rolledBack)
+		}
+		if !strings.Contains(out.String(), "RUNNING: web-test") {
+			t.Errorf("%s: test output not written: %q", tt.name, out.String())
+		}
+	}
+}
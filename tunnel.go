@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+)
+
+const (
+	tillerPort         = 44134
+	tillerPodSelector  = "app=helm,name=tiller"
+	portForwardChannel = "v4.channel.k8s.io"
+)
+
+// tunnel forwards a local port to a port of a pod through the Kubernetes API,
+// like kubectl port-forward does. It uses the WebSocket variant of the
+// port-forward protocol: every message starts with a channel byte, 0 for data
+// and 1 for errors, and each channel starts with the two byte port number.
+type tunnel struct {
+	kube     *kubeClient
+	path     string
+	listener net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// newTillerTunnel finds a running Tiller pod in namespace and starts
+// forwarding a local port to it.
+func newTillerTunnel(kube *kubeClient, namespace string) (*tunnel, error) {
+	pod, err := findTillerPod(kube, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTunnel(kube, namespace, pod, tillerPort)
+}
+
+func findTillerPod(kube *kubeClient, namespace string) (string, error) {
+	var pods struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Phase string `json:"phase"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+
+	path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods"
+	if err := kube.do("GET", path, url.Values{"labelSelector": {tillerPodSelector}}, nil, &pods); err != nil {
+		return "", err
+	}
+
+	for _, p := range pods.Items {
+		if p.Status.Phase == "Running" {
+			return p.Metadata.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("could not find a running tiller pod in namespace %q", namespace)
+}
+
+func newTunnel(kube *kubeClient, namespace, pod string, port int) (*tunnel, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tunnel{
+		kube:     kube,
+		path:     fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward?ports=%d", url.PathEscape(namespace), url.PathEscape(pod), port),
+		listener: l,
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	t.wg.Add(1)
+	go t.serve()
+
+	return t, nil
+}
+
+// Addr is the local address to connect to.
+func (t *tunnel) Addr() string {
+	return t.listener.Addr().String()
+}
+
+func (t *tunnel) serve() {
+	defer t.wg.Done()
+
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		t.track(conn, true)
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			defer t.track(conn, false)
+			if err := t.forward(conn); err != nil {
+				fmt.Fprintf(os.Stderr, "port-forward to tiller: %s\n", err)
+			}
+		}()
+	}
+}
+
+func (t *tunnel) track(conn net.Conn, add bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if add {
+		t.conns[conn] = struct{}{}
+	} else {
+		delete(t.conns, conn)
+		conn.Close()
+	}
+}
+
+func (t *tunnel) forward(local net.Conn) error {
+	ws, err := t.kube.dialWebsocket(t.path, portForwardChannel)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- t.copyFromPod(local, ws)
+		local.Close()
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := local.Read(buf)
+		if n > 0 {
+			msg := append([]byte{0}, buf[:n]...)
+			if werr := ws.WriteMessage(wsBinary, msg); werr != nil {
+				return werr
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	ws.Close()
+
+	// Errors reading from a connection closed above are expected, only
+	// errors reported by the pod are of interest.
+	if err, ok := (<-errc).(*podError); ok {
+		return err
+	}
+	return nil
+}
+
+// podError is an error sent by the kubelet on the error channel.
+type podError struct {
+	msg string
+}
+
+func (e *podError) Error() string {
+	return e.msg
+}
+
+func (t *tunnel) copyFromPod(local net.Conn, ws *wsConn) error {
+	var prefix [2]int
+
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if len(msg) == 0 {
+			continue
+		}
+
+		ch, payload := msg[0], msg[1:]
+		if ch > 1 {
+			continue
+		}
+
+		// Skip the port number each channel starts with.
+		if skip := 2 - prefix[ch]; skip > 0 {
+			if skip > len(payload) {
+				skip = len(payload)
+			}
+			prefix[ch] += skip
+			payload = payload[skip:]
+		}
+		if len(payload) == 0 {
+			continue
+		}
+
+		if ch == 1 {
+			return &podError{msg: string(payload)}
+		}
+		if _, err := local.Write(payload); err != nil {
+			return err
+		}
+	}
+}
+
+// Close stops listening, closes all forwarded connections and waits for them
+// to finish.
+func (t *tunnel) Close() error {
+	err := t.listener.Close()
+
+	t.mu.Lock()
+	for conn := range t.conns {
+		conn.Close()
+	}
+	t.mu.Unlock()
+
+	t.wg.Wait()
+	return err
+}
+
+// defaultTillerNamespace returns the namespace Tiller runs in, as helm determines it.
+func defaultTillerNamespace() string {
+	if ns := os.Getenv("TILLER_NAMESPACE"); ns != "" {
+		return ns
+	}
+	return "kube-system"
+}
+
+// tillerHost returns the Tiller address given by helm, if any.
+func tillerHost() string {
+	for _, env := range []string{"TILLER_HOST", "HELM_HOST"} {
+		if h := os.Getenv(env); h != "" {
+			return h
+		}
+	}
+	return ""
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/burdiyan/helm-update-config/pkg/updater"
+	"github.com/spf13/pflag"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// watchFlags control watching the rollout of a new revision.
+type watchFlags struct {
+	enabled  bool
+	interval time.Duration
+}
+
+func (f *watchFlags) register(fs *pflag.FlagSet) {
+	fs.BoolVar(&f.enabled, "watch", false, "show the progress of the rollout until the release is ready, for at most --timeout seconds")
+	fs.DurationVar(&f.interval, "watch-interval", 2*time.Second, "how often to poll the status of the release with --watch")
+}
+
+// check validates the flags before the update is made.
+func (f *watchFlags) check(backend releaseBackend) error {
+	if _, ok := backend.(statusBackend); f.enabled && !ok {
+		return fmt.Errorf("--watch is not supported by the %s backend", backendName)
+	}
+	if f.interval <= 0 {
+		return errors.New("--watch-interval must be positive")
+	}
+	return nil
+}
+
+// Pods have to be seen failing this many times in a row for the rollout to
+// fail, so that a container restarting once does not end the watch.
+const watchFailureThreshold = 3
+
+// failingPodStates are pod states that do not go away without a change.
+var failingPodStates = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"Error":                      true,
+}
+
+// run polls the status of the new revision and writes the progress of the
+// rollout to w whenever it changes. It returns an error if the release fails
+// or is not ready within timeout seconds.
+func (f *watchFlags) run(backend releaseBackend, res *updater.Result, timeout int64, w io.Writer) error {
+	sb := backend.(statusBackend)
+
+	var (
+		deadline = time.Now().Add(time.Duration(timeout) * time.Second)
+		failing  = make(map[string]int)
+		last     string
+		state    rolloutState
+	)
+
+	for {
+		st, err := sb.Status(res.Release, res.Revision)
+		if err != nil {
+			fmt.Fprintf(w, "could not get the status of %s: %s\n", res.Release, err)
+		} else {
+			if st.GetCode() == release.Status_FAILED {
+				return fmt.Errorf("release %s failed: revision %d is in state %s", res.Release, res.Revision, st.GetCode())
+			}
+
+			state = newRolloutState(parseResources(st.GetResources()))
+			if p := state.String(); p != last {
+				fmt.Fprintln(w, p)
+				last = p
+			}
+
+			if state.ready() {
+				fmt.Fprintf(w, "Release %s is ready at revision %d\n", res.Release, res.Revision)
+				return nil
+			}
+
+			for pod := range failing {
+				if _, ok := state.failing[pod]; !ok {
+					delete(failing, pod)
+				}
+			}
+			for pod, reason := range state.failing {
+				failing[pod]++
+				if failing[pod] >= watchFailureThreshold {
+					return fmt.Errorf("release %s failed: pod %s is in state %s", res.Release, pod, reason)
+				}
+			}
+		}
+
+		if time.Now().Add(f.interval).After(deadline) {
+			return fmt.Errorf("timed out after %ds waiting for release %s to be ready: %s", timeout, res.Release, state)
+		}
+		time.Sleep(f.interval)
+	}
+}
+
+// workload is the readiness of a Deployment, StatefulSet or DaemonSet.
+type workload struct {
+	name    string
+	ready   int
+	desired int
+}
+
+// rolloutState summarizes the resources of a release during a rollout.
+type rolloutState struct {
+	workloads []workload
+	// pending maps pods which are not ready to their state.
+	pending map[string]string
+	// failing maps pods in a failingPodStates state to it.
+	failing map[string]string
+}
+
+func newRolloutState(resources []resource) rolloutState {
+	s := rolloutState{
+		pending: make(map[string]string),
+		failing: make(map[string]string),
+	}
+
+	for _, r := range resources {
+		switch r.Kind {
+		case "Deployment", "StatefulSet", "DaemonSet":
+			ready, desired := replicas(r.Columns)
+			s.workloads = append(s.workloads, workload{name: r.Kind + "/" + r.Name, ready: ready, desired: desired})
+		case "Pod":
+			status := r.Columns["STATUS"]
+			ready, desired, _ := parseRatio(r.Columns["READY"])
+			if status == "Completed" || status == "Succeeded" || (status == "Running" && ready == desired) {
+				continue
+			}
+			s.pending[r.Name] = status
+			if failingPodStates[status] {
+				s.failing[r.Name] = status
+			}
+		}
+	}
+
+	return s
+}
+
+// replicas reads the ready and desired replicas of a workload from the
+// columns kubectl prints for it, which differ between kinds and versions.
+func replicas(c map[string]string) (int, int) {
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	min := func(a, b int) int {
+		if a < b {
+			return a
+		}
+		return b
+	}
+
+	if ready, desired, ok := parseRatio(c["READY"]); ok {
+		if v, ok := c["UP-TO-DATE"]; ok {
+			ready = min(ready, atoi(v))
+		}
+		if v, ok := c["AVAILABLE"]; ok {
+			ready = min(ready, atoi(v))
+		}
+		return ready, desired
+	}
+
+	desired := atoi(c["DESIRED"])
+	switch {
+	case c["READY"] != "":
+		return min(atoi(c["READY"]), atoi(c["UP-TO-DATE"])), desired
+	case c["AVAILABLE"] != "":
+		return min(atoi(c["AVAILABLE"]), atoi(c["UP-TO-DATE"])), desired
+	}
+	return atoi(c["CURRENT"]), desired
+}
+
+// parseRatio parses a "ready/desired" column.
+func parseRatio(s string) (int, int, bool) {
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return 0, 0, false
+	}
+	a, err1 := strconv.Atoi(s[:i])
+	b, err2 := strconv.Atoi(s[i+1:])
+	return a, b, err1 == nil && err2 == nil
+}
+
+func (s rolloutState) ready() bool {
+	for _, w := range s.workloads {
+		if w.ready < w.desired {
+			return false
+		}
+	}
+	return len(s.pending) == 0
+}
+
+func (s rolloutState) String() string {
+	var parts []string
+	for _, w := range s.workloads {
+		parts = append(parts, fmt.Sprintf("%s %d/%d ready", w.name, w.ready, w.desired))
+	}
+
+	if len(s.pending) > 0 {
+		var pods []string
+		for pod, status := range s.pending {
+			pods = append(pods, fmt.Sprintf("%s (%s)", pod, status))
+		}
+		sort.Strings(pods)
+		parts = append(parts, "pending pods: "+strings.Join(pods, ", "))
+	}
+
+	if len(parts) == 0 {
+		return "no workloads"
+	}
+	return strings.Join(parts, "; ")
+}
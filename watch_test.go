@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReplicas(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns map[string]string
+		ready   int
+		desired int
+	}{
+		{"deployment, kubectl 1.15", map[string]string{"READY": "2/3", "UP-TO-DATE": "3", "AVAILABLE": "2"}, 2, 3},
+		{"deployment with old pods ready", map[string]string{"READY": "3/3", "UP-TO-DATE": "1", "AVAILABLE": "3"}, 1, 3},
+		{"deployment, kubectl 1.10", map[string]string{"DESIRED": "3", "CURRENT": "3", "UP-TO-DATE": "2", "AVAILABLE": "3"}, 2, 3},
+		{"statefulset, kubectl 1.15", map[string]string{"READY": "1/2"}, 1, 2},
+		{"statefulset, kubectl 1.10", map[string]string{"DESIRED": "2", "CURRENT": "1"}, 1, 2},
+		{"daemonset", map[string]string{"DESIRED": "3", "CURRENT": "3", "READY": "3", "UP-TO-DATE": "2", "AVAILABLE": "3"}, 2, 3},
+	}
+	for _, tt := range tests {
+		if ready, desired := replicas(tt.columns); ready != tt.ready || desired != tt.desired {
+			t.Errorf("%s: got %d/%d, want %d/%d", tt.name, ready, desired, tt.ready, tt.desired)
+		}
+	}
+}
+
+func TestNewRolloutState(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   string
+		state   string
+		ready   bool
+		failing string
+	}{
+		{
+			name: "rolling out",
+			table: `==> v1/Deployment
+NAME  READY  UP-TO-DATE  AVAILABLE  AGE
+web   1/2    2           1          5d
+
+==> v1/Pod(related)
+NAME                 READY  STATUS             RESTARTS  AGE
+web-5d8f7c9b4-2xkqz  1/1    Running            0         10s
+web-5d8f7c9b4-9lmwp  0/1    ContainerCreating  0         2s
+web-7b9c6d5f8-q4r2t  1/1    Terminating        0         5d
+`,
+			state: "Deployment/web 1/2 ready; pending pods: web-5d8f7c9b4-9lmwp (ContainerCreating), web-7b9c6d5f8-q4r2t (Terminating)",
+		},
+		{
+			name: "crashing",
+			table: `==> v1/StatefulSet
+NAME  READY  AGE
+db    0/1    5d
+
+==> v1/Pod(related)
+NAME  READY  STATUS            RESTARTS  AGE
+db-0  0/1    CrashLoopBackOff  4         2m
+`,
+			state:   "StatefulSet/db 0/1 ready; pending pods: db-0 (CrashLoopBackOff)",
+			failing: "map[db-0:CrashLoopBackOff]",
+		},
+		{
+			name: "ready",
+			table: `==> v1/Deployment
+NAME  READY  UP-TO-DATE  AVAILABLE  AGE
+web   2/2    2           2          5d
+
+==> v1/Pod(related)
+NAME                 READY  STATUS     RESTARTS  AGE
+web-5d8f7c9b4-2xkqz  1/1    Running    0         1m
+web-5d8f7c9b4-9lmwp  1/1    Running    0         1m
+web-migrate-x7k2p    0/1    Completed  0         2m
+`,
+			state: "Deployment/web 2/2 ready",
+			ready: true,
+		},
+		{
+			name: "not ready container",
+			table: `==> v1/Pod(related)
+NAME  READY  STATUS   RESTARTS  AGE
+web   1/2    Running  0         1m
+`,
+			state: "pending pods: web (Running)",
+		},
+		{
+			name: "no workloads",
+			table: `==> v1/ConfigMap
+NAME        DATA  AGE
+web-config  2     5d
+`,
+			state: "no workloads",
+			ready: true,
+		},
+	}
+	for _, tt := range tests {
+		s := newRolloutState(parseResources(tt.table))
+		if s.String() != tt.state {
+			t.Errorf("%s: got state %q, want %q", tt.name, s.String(), tt.state)
+		}
+		if s.ready() != tt.ready {
+			t.Errorf("%s: got ready %v, want %v", tt.name, s.ready(), tt.ready)
+		}
+		if failing := fmt.Sprint(s.failing); tt.failing != "" && failing != tt.failing || tt.failing == "" && len(s.failing) > 0 {
+			t.Errorf("%s: got failing pods %s, want %s", tt.name, failing, tt.failing)
+		}
+	}
+}
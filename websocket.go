@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wsConn is a minimal RFC 6455 WebSocket client connection, enough to talk
+// to the streaming endpoints of the Kubernetes API.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	mu     sync.Mutex // serializes writes
+	closed bool
+}
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// wsMaxMessage limits the size of frames and messages read, so a broken
+	// or hostile server cannot make the client allocate without bounds.
+	wsMaxMessage = 16 << 20
+	// wsMaxControl is the largest payload of a control frame, per RFC 6455.
+	wsMaxControl = 125
+)
+
+// dialWebsocket opens a WebSocket connection to an API path of the cluster.
+func (c *kubeClient) dialWebsocket(path string, protocols ...string) (*wsConn, error) {
+	u, err := url.Parse(c.server + path)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			addr += ":443"
+		} else {
+			addr += ":80"
+		}
+	}
+
+	conn, err := c.dial(u, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "https" {
+		cfg := c.tls.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tc := tls.Client(conn, cfg)
+		tc.SetDeadline(time.Now().Add(kubeClientTimeout))
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(protocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	}
+	c.authorize(req)
+
+	conn.SetDeadline(time.Now().Add(kubeClientTimeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		conn.Close()
+		return nil, fmt.Errorf("websocket upgrade failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		conn.Close()
+		return nil, errors.New("websocket upgrade failed: invalid Sec-WebSocket-Accept")
+	}
+
+	conn.SetDeadline(time.Time{})
+	return &wsConn{conn: conn, r: r}, nil
+}
+
+// dial opens a TCP connection to addr, the host of u, through the proxy the
+// REST client would use for u.
+func (c *kubeClient) dial(u *url.URL, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: kubeClientTimeout}
+
+	var proxy *url.URL
+	if c.proxy != nil {
+		var err error
+		if proxy, err = c.proxy(&http.Request{URL: u}); err != nil {
+			return nil, err
+		}
+	}
+	if proxy == nil {
+		return dialer.Dial("tcp", addr)
+	}
+
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		if proxy.Scheme == "https" {
+			proxyAddr += ":443"
+		} else {
+			proxyAddr += ":80"
+		}
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if proxy.Scheme == "https" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", proxyAddr, &tls.Config{ServerName: proxy.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", proxyAddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	connect := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		connect.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	conn.SetDeadline(time.Now().Add(kubeClientTimeout))
+	if err := connect.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The proxy sends nothing after its response until the server does, so
+	// nothing is lost by reading it through a buffer. The body of a successful
+	// response is the tunnel itself and is left alone.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), connect)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: CONNECT %s: %s", proxy.Host, addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+// WriteMessage sends data as a single masked frame.
+func (c *wsConn) WriteMessage(opcode byte, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return errors.New("websocket: write on closed connection")
+	}
+
+	return c.writeFrame(opcode, data)
+}
+
+func (c *wsConn) writeFrame(opcode byte, data []byte) error {
+	header := []byte{0x80 | opcode, 0}
+
+	switch n := len(data); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	// Frames sent by a client have to be masked.
+	header[1] |= 0x80
+	mask := make([]byte, 4)
+	if _, err := rand.Read(mask); err != nil {
+		return err
+	}
+	header = append(header, mask...)
+
+	frame := make([]byte, len(header)+len(data))
+	copy(frame, header)
+	for i, b := range data {
+		frame[len(header)+i] = b ^ mask[i%4]
+	}
+
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// ReadMessage returns the next data message. Control frames are handled
+// internally; io.EOF is returned once the server closes the connection.
+func (c *wsConn) ReadMessage() (byte, []byte, error) {
+	var (
+		opcode  byte
+		message []byte
+	)
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case wsPing:
+			c.mu.Lock()
+			err := c.writeFrame(wsPong, payload)
+			c.mu.Unlock()
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.Close()
+			return 0, nil, io.EOF
+		case wsContinuation:
+		default:
+			opcode = op
+		}
+
+		if len(message)+len(payload) > wsMaxMessage {
+			return 0, nil, fmt.Errorf("websocket: message larger than %d bytes", wsMaxMessage)
+		}
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	n := uint64(header[1] & 0x7f)
+
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if n > wsMaxMessage || (opcode >= wsClose && n > wsMaxControl) {
+		return false, 0, nil, fmt.Errorf("websocket: frame of %d bytes is too large", n)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// Close sends a close frame and closes the underlying connection.
+func (c *wsConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	c.writeFrame(wsClose, []byte{0x03, 0xe8}) // 1000: normal closure
+	return c.conn.Close()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// echoWebsocket accepts a websocket upgrade and sends back every message it
+// receives, prefixed with "echo: ".
+func echoWebsocket(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsGUID))
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(sum[:]))
+		w.WriteHeader(http.StatusSwitchingProtocols)
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		ws := &wsConn{conn: conn, r: rw.Reader}
+		op, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ws.WriteMessage(op, append([]byte("echo: "), msg...))
+	}))
+}
+
+// connectProxy tunnels CONNECT requests and remembers their targets.
+type connectProxy struct {
+	mu      sync.Mutex
+	targets []string
+	auth    []string
+}
+
+func (p *connectProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "CONNECT" {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	p.mu.Lock()
+	p.targets = append(p.targets, r.Host)
+	p.auth = append(p.auth, r.Header.Get("Proxy-Authorization"))
+	p.mu.Unlock()
+
+	target, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer target.Close()
+
+	w.WriteHeader(http.StatusOK)
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+func TestDialWebsocket(t *testing.T) {
+	srv := echoWebsocket(t)
+	defer srv.Close()
+
+	proxy := &connectProxy{}
+	proxySrv := httptest.NewServer(proxy)
+	defer proxySrv.Close()
+	proxyURL, _ := url.Parse(proxySrv.URL)
+	proxyURL.User = url.UserPassword("user", "pw")
+
+	for _, viaProxy := range []bool{false, true} {
+		c := &kubeClient{server: srv.URL}
+		if viaProxy {
+			c.proxy = http.ProxyURL(proxyURL)
+		}
+
+		ws, err := c.dialWebsocket("/api/v1/namespaces/kube-system/pods/tiller/portforward", portForwardChannel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ws.WriteMessage(wsBinary, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		op, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if op != wsBinary || string(msg) != "echo: hello" {
+			t.Errorf("got message %d %q", op, msg)
+		}
+		ws.Close()
+	}
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	if len(proxy.targets) != 1 || proxy.targets[0] != host {
+		t.Errorf("got proxied connections to %v, want one to %s", proxy.targets, host)
+	}
+	if want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pw")); proxy.auth[0] != want {
+		t.Errorf("got proxy authorization %q", proxy.auth[0])
+	}
+}
+
+func TestReadFrameLimits(t *testing.T) {
+	frame := func(opcode byte, fin bool, n uint64) []byte {
+		b := []byte{opcode, 127}
+		if fin {
+			b[0] |= 0x80
+		}
+		b = append(b, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(b[2:], n)
+		return b
+	}
+
+	tests := []struct {
+		name  string
+		input []byte
+		err   string
+	}{
+		{
+			name:  "huge frame",
+			input: frame(wsBinary, true, 1<<62),
+			err:   "too large",
+		},
+		{
+			name:  "large control frame",
+			input: frame(wsPing, true, 200),
+			err:   "too large",
+		},
+		{
+			name: "message of too many fragments",
+			input: bytes.Join([][]byte{
+				frame(wsBinary, false, wsMaxMessage), make([]byte, wsMaxMessage),
+				frame(wsContinuation, true, 1), {0},
+			}, nil),
+			err: "message larger than",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go func() {
+				server.Write(tt.input)
+				server.Close()
+			}()
+
+			ws := &wsConn{conn: client, r: bufio.NewReader(client)}
+			_, _, err := ws.ReadMessage()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}